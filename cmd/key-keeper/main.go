@@ -2,9 +2,12 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -12,12 +15,21 @@ import (
 	"github.com/fraima/key-keeper/internal/controller"
 	"github.com/fraima/key-keeper/internal/issuer/vault"
 	"github.com/fraima/key-keeper/internal/issuer/vault/client"
+	"github.com/fraima/key-keeper/internal/metrics"
+	"github.com/fraima/key-keeper/internal/server"
 )
 
 var (
 	Version = "undefined"
 )
 
+// commands of the control socket client.
+var commands = map[string]func(args []string) error{
+	"status":   runStatus,
+	"renew":    runRenew,
+	"rollback": runRollback,
+}
+
 func main() {
 	loggerConfig := zap.NewProductionConfig()
 	loggerConfig.Level.SetLevel(zap.DebugLevel)
@@ -27,9 +39,31 @@ func main() {
 	}
 	zap.ReplaceGlobals(logger)
 
-	var configDir, configNameLayout string
+	if len(os.Args) > 1 {
+		if cmd, isExist := commands[os.Args[1]]; isExist {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	var (
+		configDir, configNameLayout                 string
+		metricsAddr, healthAddr, controlSocket      string
+		configInterval, ensureInterval, renewJitter time.Duration
+		once                                        bool
+	)
 	flag.StringVar(&configDir, "config-dir", "", "path to dir with configs")
 	flag.StringVar(&configNameLayout, "config-regexp", "", "regexp for config files names")
+	flag.DurationVar(&configInterval, "config-interval", 30*time.Second, "interval of config dir rescan")
+	flag.DurationVar(&ensureInterval, "ensure-interval", 30*time.Second, "default interval of resource check")
+	flag.DurationVar(&renewJitter, "renew-jitter", 10*time.Minute, "max random shift of certificate renewal before renewBefore, limited by a quarter of the certificate lifetime")
+	flag.BoolVar(&once, "once", false, "ensure every resource once and exit")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "address of prometheus metrics listener, disabled if empty")
+	flag.StringVar(&healthAddr, "health-addr", "", "address of /healthz and /readyz listener, may be equal to metrics-addr, disabled if empty")
+	flag.StringVar(&controlSocket, "control-socket", defaultControlSocket, "path to control socket, disabled if empty")
 	flag.Parse()
 
 	if configDir == "" {
@@ -40,6 +74,10 @@ func main() {
 		zap.L().Fatal("not found regexp for config file's name")
 	}
 
+	if configInterval <= 0 || ensureInterval <= 0 {
+		zap.L().Fatal("intervals must be positive")
+	}
+
 	cfg, err := config.New(configDir, configNameLayout)
 	if err != nil {
 		zap.L().Fatal("read configuration", zap.Error(err))
@@ -48,21 +86,62 @@ func main() {
 	zap.L().Debug("configuration", zap.Any("config", cfg), zap.String("version", Version))
 
 	cntl := controller.New(
-		cfg.GetNewConfig,
+		cfg.GetChanges,
+		cfg.Watch,
 		vault.Connector(
 			client.Connect,
 		),
+		configInterval,
+		ensureInterval,
+		renewJitter,
 	)
 
+	if once {
+		if err := cntl.RunOnce(); err != nil {
+			zap.L().Fatal("run once", zap.Error(err))
+		}
+		zap.L().Info("done")
+		return
+	}
+
+	muxes := make(map[string]*http.ServeMux)
+	if metricsAddr != "" {
+		muxes[metricsAddr] = http.NewServeMux()
+		muxes[metricsAddr].Handle("/metrics", metrics.Handler())
+	}
+	if healthAddr != "" {
+		if _, isExist := muxes[healthAddr]; !isExist {
+			muxes[healthAddr] = http.NewServeMux()
+		}
+		server.RegisterHealth(muxes[healthAddr], cntl)
+	}
+	for addr, mux := range muxes {
+		go func(addr string, mux *http.ServeMux) {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				zap.L().Fatal("http listener", zap.String("addr", addr), zap.Error(err))
+			}
+		}(addr, mux)
+	}
+
 	if err := cntl.Start(); err != nil {
 		zap.L().Fatal("start controller", zap.Error(err))
 	}
 
+	if controlSocket != "" {
+		control, err := server.ListenControl(controlSocket, cntl)
+		if err != nil {
+			zap.L().Error("control socket", zap.String("path", controlSocket), zap.Error(err))
+		} else {
+			defer control.Close()
+		}
+	}
+
 	zap.L().Info("started")
 
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	<-ch
 
+	cntl.Stop()
 	zap.L().Info("goodbye")
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fraima/key-keeper/internal/server"
+)
+
+var errRenewFailed = errors.New("renewal failed")
+
+// runRenew forces the running daemon to reissue certificates.
+func runRenew(args []string) error {
+	fs := flag.NewFlagSet("renew", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: key-keeper renew [-control-socket path] <issuer>/<certificate> | --all")
+		fs.PrintDefaults()
+	}
+	controlSocket := fs.String("control-socket", defaultControlSocket, "path to control socket of the daemon")
+	all := fs.Bool("all", false, "renew all certificates")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var issuer, name string
+	switch {
+	case *all && fs.NArg() == 0:
+	case !*all && fs.NArg() == 1:
+		var isFound bool
+		issuer, name, isFound = strings.Cut(fs.Arg(0), "/")
+		if !isFound || issuer == "" || name == "" {
+			fs.Usage()
+			os.Exit(2)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	results, err := server.NewControlClient(*controlSocket).Renew(issuer, name)
+	if err != nil {
+		return fmt.Errorf("renew: %w", err)
+	}
+	printRenewResults(os.Stdout, results)
+
+	for _, r := range results {
+		if r.Error != "" {
+			return errRenewFailed
+		}
+	}
+	return nil
+}
+
+func printRenewResults(out io.Writer, results []server.RenewResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ISSUER\tNAME\tACTION\tSERIAL\tNOT AFTER\tERROR")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Issuer,
+			r.Name,
+			r.Action,
+			orDash(r.Serial),
+			timestamp(r.NotAfter),
+			orDash(r.Error),
+		)
+	}
+	w.Flush()
+}
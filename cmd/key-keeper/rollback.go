@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fraima/key-keeper/internal/server"
+)
+
+var errRollbackFailed = errors.New("rollback failed")
+
+// runRollback makes the running daemon restore the previous generation of the certificate.
+func runRollback(args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: key-keeper rollback [-control-socket path] <issuer>/<certificate> [-to serial]")
+		fs.PrintDefaults()
+	}
+	controlSocket := fs.String("control-socket", defaultControlSocket, "path to control socket of the daemon")
+	to := fs.String("to", "", "serial of the generation, the previous generation if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// flags are allowed after the certificate too
+	ref := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return err
+	}
+	issuer, name, isFound := strings.Cut(ref, "/")
+	if fs.NArg() != 0 || !isFound || issuer == "" || name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	result, err := server.NewControlClient(*controlSocket).Rollback(issuer, name, *to)
+	if err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	printRenewResults(os.Stdout, []server.RenewResult{result})
+
+	if result.Error != "" {
+		return errRollbackFailed
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fraima/key-keeper/internal/server"
+)
+
+const defaultControlSocket = "/run/key-keeper.sock"
+
+// runStatus prints the state of the running daemon.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	controlSocket := fs.String("control-socket", defaultControlSocket, "path to control socket of the daemon")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	st, err := server.NewControlClient(*controlSocket).Status()
+	if err != nil {
+		return fmt.Errorf("query daemon: %w", err)
+	}
+	printStatus(os.Stdout, st, time.Now())
+	return nil
+}
+
+func printStatus(out io.Writer, st server.Status, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ISSUER\tCONNECTED\tTOKEN TTL")
+	for _, i := range st.Issuers {
+		fmt.Fprintf(w, "%s\t%t\t%s\n", i.Name, i.Connected, until(i.TokenExpiry, now))
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "ISSUER\tTYPE\tNAME\tPATH\tSERIAL\tNOT AFTER\tRENEW AT\tLAST ERROR")
+	for _, r := range st.Resources {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Issuer,
+			r.Type,
+			r.Name,
+			orDash(r.Path),
+			orDash(r.Serial),
+			timestamp(r.NotAfter),
+			timestamp(r.RenewAt),
+			orDash(r.Error),
+		)
+	}
+	w.Flush()
+}
+
+func until(t, now time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Sub(now).Truncate(time.Second).String()
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
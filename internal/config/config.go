@@ -1,19 +1,57 @@
 package config
 
 import (
+	"crypto/sha256"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"sync"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
 
+// Op is a kind of config file change.
+type Op int
+
+const (
+	Create Op = iota + 1
+	Write
+	Remove
+)
+
+func (o Op) String() string {
+	switch o {
+	case Create:
+		return "create"
+	case Write:
+		return "write"
+	case Remove:
+		return "remove"
+	}
+	return "unknown"
+}
+
+// Change describes config file change.
+// For removed file Config contains the last known content of the file.
+type Change struct {
+	Op     Op
+	Path   string
+	Config Config
+}
+
+type file struct {
+	hash [sha256.Size]byte
+	cfg  Config
+}
+
 type config struct {
 	dir string
 	reg *regexp.Regexp
 
-	oldConfig map[string]struct{}
+	mu    sync.Mutex
+	files map[string]file
 }
 
 // New return interface for work with config.
@@ -27,17 +65,38 @@ func New(configDir, configNameLayout string) (*config, error) {
 		dir: configDir,
 		reg: reg,
 
-		oldConfig: make(map[string]struct{}),
+		files: make(map[string]file),
 	}, nil
 }
 
-// GetNewConfig return new config from config dir.
-func (s *config) GetNewConfig() (cfg Config, err error) {
-	list, err := s.getNewConfigFiles()
+// GetChanges return added, changed and removed config files from config dir since the previous call.
+func (s *config) GetChanges() (changes []Change, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, err := s.getConfigFiles()
 	if err != nil {
 		return
 	}
 
+	exists := make(map[string]struct{}, len(list))
+	for _, path := range list {
+		exists[path] = struct{}{}
+	}
+
+	var removed []string
+	for path := range s.files {
+		if _, ok := exists[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, path := range removed {
+		changes = append(changes, Change{Op: Remove, Path: path, Config: s.files[path].cfg})
+		delete(s.files, path)
+	}
+
 	for _, path := range list {
 		data, err := os.ReadFile(path)
 		if err != nil {
@@ -45,32 +104,41 @@ func (s *config) GetNewConfig() (cfg Config, err error) {
 			continue
 		}
 
-		var tmpCfg Config
-		if err = yaml.Unmarshal(data, &tmpCfg); err != nil {
+		hash := sha256.Sum256(data)
+		old, isOld := s.files[path]
+		if isOld && old.hash == hash {
+			continue
+		}
+
+		var cfg Config
+		if err = yaml.Unmarshal(data, &cfg); err != nil {
 			zap.L().Error("unmarshal config file", zap.String("path", path), zap.Error(err))
+			// remember the broken content to not report it on every call, the last valid config stays in use
+			s.files[path] = file{hash: hash, cfg: old.cfg}
 			continue
 		}
+		s.files[path] = file{hash: hash, cfg: cfg}
 
-		cfg.Issuers = append(cfg.Issuers, tmpCfg.Issuers...)
-		cfg.Resource.Certificates = append(cfg.Resource.Certificates, tmpCfg.Resource.Certificates...)
-		cfg.Resource.Secrets = append(cfg.Resource.Secrets, tmpCfg.Resource.Secrets...)
-		s.oldConfig[path] = struct{}{}
+		op := Create
+		if isOld {
+			op = Write
+		}
+		changes = append(changes, Change{Op: op, Path: path, Config: cfg})
 	}
 	return
 }
 
-func (s *config) getNewConfigFiles() ([]string, error) {
-	var newConfigFiles []string
+func (s *config) getConfigFiles() ([]string, error) {
+	var configFiles []string
 	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
 
-		_, isOld := s.oldConfig[path]
-		if !isOld && !info.IsDir() && s.reg.Match([]byte(info.Name())) {
-			newConfigFiles = append(newConfigFiles, path)
+		if !info.IsDir() && s.reg.Match([]byte(info.Name())) {
+			configFiles = append(configFiles, path)
 		}
 		return nil
 	})
-	return newConfigFiles, err
+	return configFiles, err
 }
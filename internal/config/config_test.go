@@ -0,0 +1,113 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetChanges(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := New(dir, `.*\.conf$`)
+	require.NoError(t, err)
+
+	var (
+		a = filepath.Join(dir, "a.conf")
+		b = filepath.Join(dir, "sub", "b.conf")
+
+		secretA  = Config{Resource: Resources{Secrets: []Secret{{Name: "a"}}}}
+		secretA2 = Config{Resource: Resources{Secrets: []Secret{{Name: "a2"}}}}
+		secretB  = Config{Resource: Resources{Secrets: []Secret{{Name: "b"}}}}
+	)
+
+	// steps share the config dir, so they run in order
+	steps := []struct {
+		name string
+		do   func(t *testing.T)
+		want []Change
+	}{
+		{
+			name: "create",
+			do: func(t *testing.T) {
+				writeFile(t, a, "secrets:\n  - name: a\n")
+				writeFile(t, filepath.Join(dir, "notes.txt"), "secrets:\n  - name: x\n")
+			},
+			want: []Change{{Op: Create, Path: a, Config: secretA}},
+		},
+		{
+			name: "unchanged",
+			do:   func(t *testing.T) {},
+		},
+		{
+			name: "create in subdir",
+			do: func(t *testing.T) {
+				writeFile(t, b, "secrets:\n  - name: b\n")
+			},
+			want: []Change{{Op: Create, Path: b, Config: secretB}},
+		},
+		{
+			name: "write",
+			do: func(t *testing.T) {
+				writeFile(t, a, "secrets:\n  - name: a2\n")
+			},
+			want: []Change{{Op: Write, Path: a, Config: secretA2}},
+		},
+		{
+			name: "broken yaml keeps the last valid config",
+			do: func(t *testing.T) {
+				writeFile(t, a, "secrets: [\n")
+			},
+		},
+		{
+			name: "fixed yaml",
+			do: func(t *testing.T) {
+				writeFile(t, a, "secrets:\n  - name: a\n")
+			},
+			want: []Change{{Op: Write, Path: a, Config: secretA}},
+		},
+		{
+			name: "remove returns the last config",
+			do: func(t *testing.T) {
+				require.NoError(t, os.Remove(a))
+			},
+			want: []Change{{Op: Remove, Path: a, Config: secretA}},
+		},
+		{
+			name: "create broken yaml",
+			do: func(t *testing.T) {
+				writeFile(t, a, "secrets: [\n")
+			},
+		},
+		{
+			name: "remove broken yaml",
+			do: func(t *testing.T) {
+				require.NoError(t, os.Remove(a))
+				require.NoError(t, os.Remove(b))
+			},
+			want: []Change{
+				{Op: Remove, Path: a},
+				{Op: Remove, Path: b, Config: secretB},
+			},
+		},
+	}
+
+	for _, step := range steps {
+		if !t.Run(step.name, func(t *testing.T) {
+			step.do(t)
+			changes, err := cfg.GetChanges()
+			require.NoError(t, err)
+			assert.Equal(t, step.want, changes)
+		}) {
+			return
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+}
@@ -18,22 +18,89 @@ type Resources struct {
 }
 
 type Certificate struct {
-	Name        string        `yaml:"name"`
-	IssuerRef   IssuerRef     `yaml:"issuerRef"`
-	IsCA        bool          `yaml:"isCa"`
-	CA          CA            `yaml:"ca"`
-	Spec        Spec          `yaml:"spec"`
-	HostPath    string        `yaml:"hostPath"`
-	WithUpdate  bool          `yaml:"withUpdate"`
-	RenewBefore time.Duration `yaml:"renewBefore"`
-	Trigger     [][]string    `yaml:"trigger"`
+	Name          string        `yaml:"name"`
+	IssuerRef     IssuerRef     `yaml:"issuerRef"`
+	IsCA          bool          `yaml:"isCa"`
+	CA            CA            `yaml:"ca"`
+	Spec          Spec          `yaml:"spec"`
+	HostPath      string        `yaml:"hostPath"`
+	WithUpdate    bool          `yaml:"withUpdate"`
+	RenewBefore   time.Duration `yaml:"renewBefore"`
+	CheckInterval time.Duration `yaml:"checkInterval"`
+	Trigger       [][]string    `yaml:"trigger"`
+	OnDelete      OnDelete      `yaml:"onDelete"`
+	History       History       `yaml:"history"`
+	ChainFiles    ChainFiles    `yaml:"chainFiles"`
+	Outputs       []Output      `yaml:"outputs"`
+	Permissions   `yaml:",inline"`
 }
 
 type Secret struct {
-	Name      string    `yaml:"name"`
-	IssuerRef IssuerRef `yaml:"issuerRef"`
-	Key       string    `yaml:"key"`
-	HostPath  string    `yaml:"hostPath"`
+	Name          string        `yaml:"name"`
+	IssuerRef     IssuerRef     `yaml:"issuerRef"`
+	Key           string        `yaml:"key"`
+	HostPath      string        `yaml:"hostPath"`
+	CheckInterval time.Duration `yaml:"checkInterval"`
+	OnDelete      OnDelete      `yaml:"onDelete"`
+	Permissions   `yaml:",inline"`
+}
+
+// Permissions are owner and octal modes of the resource files and dirs, empty values keep the defaults.
+type Permissions struct {
+	Owner   string `yaml:"owner"`
+	Group   string `yaml:"group"`
+	Mode    string `yaml:"mode"`
+	DirMode string `yaml:"dirMode"`
+}
+
+// ChainFiles are optional files with the issuer chain written next to the leaf certificate.
+type ChainFiles struct {
+	CA        ChainFile `yaml:"ca"`
+	Chain     ChainFile `yaml:"chain"`
+	FullChain ChainFile `yaml:"fullChain"`
+	Bundle    ChainFile `yaml:"bundle"`
+}
+
+// ChainFile is written if enabled, empty name keeps the default <name>-<file>.pem.
+type ChainFile struct {
+	Enabled bool   `yaml:"enabled"`
+	Name    string `yaml:"name"`
+}
+
+// Output is a keystore built from the certificate, the key and the issuer chain,
+// a truststore with the CAs of the issuer or, if format is empty, a file with the content in the encoding.
+type Output struct {
+	Format       string       `yaml:"format"`
+	Path         string       `yaml:"path"`
+	Content      []string     `yaml:"content"`
+	Encoding     string       `yaml:"encoding"`
+	Alias        string       `yaml:"alias"`
+	Truststore   bool         `yaml:"truststore"`
+	PasswordFrom PasswordFrom `yaml:"passwordFrom"`
+}
+
+// PasswordFrom is a source of the keystore password: a local file or a key of Vault KV secret.
+type PasswordFrom struct {
+	File   string    `yaml:"file"`
+	Secret SecretKey `yaml:"secret"`
+}
+
+// SecretKey refers to a key of Vault KV secret.
+type SecretKey struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+// History is retention of the previous certificate generations, zero values keep none.
+type History struct {
+	Limit  int           `yaml:"limit"`
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+type OnDelete struct {
+	Policy     string `yaml:"policy"`
+	ArchiveDir string `yaml:"archiveDir"`
+	Revoke     bool   `yaml:"revoke"`
 }
 
 type Vault struct {
@@ -86,7 +153,17 @@ type Spec struct {
 	PrivateKey  PrivateKey  `yaml:"privateKey"`
 	Hostnames   []string    `yaml:"hostnames"`
 	IPAddresses IPAddresses `yaml:"ipAddresses"`
+	URIs        []string    `yaml:"uris"`
+	Emails      []string    `yaml:"emails"`
+	SpiffeID    SpiffeID    `yaml:"spiffeID"`
 	TTL         string      `yaml:"ttl"`
+	Usage       []string    `yaml:"usage"`
+}
+
+// SpiffeID is added to URI SANs as spiffe://<trustDomain>/<path>.
+type SpiffeID struct {
+	TrustDomain string `yaml:"trustDomain"`
+	Path        string `yaml:"path"`
 }
 
 type Subject struct {
@@ -108,7 +185,16 @@ type PrivateKey struct {
 }
 
 type IPAddresses struct {
-	Static     []string `yaml:"static"`
-	Interfaces []string `yaml:"interfaces"`
-	DNSLookup  []string `yaml:"dnsLookup"`
+	Static        []string   `yaml:"static"`
+	Interfaces    []string   `yaml:"interfaces"`
+	DNSLookup     []string   `yaml:"dnsLookup"`
+	Family        IPFamilies `yaml:"family"`
+	IncludeScoped bool       `yaml:"includeScoped"`
+}
+
+// IPFamilies are address families taken from every source: ipv4, ipv6 or both.
+type IPFamilies struct {
+	Static     string `yaml:"static"`
+	Interfaces string `yaml:"interfaces"`
+	DNSLookup  string `yaml:"dnsLookup"`
 }
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// debounce is a time of silence after the last file system event before config dir is rescanned.
+// Editors usually produce a burst of events on a single save.
+const debounce = 500 * time.Millisecond
+
+// Watch watches config dir with inotify and signals when config files may have changed until stop is closed.
+// The changes are read by GetChanges of the receiver, so they are applied in order by a single consumer.
+func (s *config) Watch(stop <-chan struct{}) (<-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("new watcher: %w", err)
+	}
+
+	if err = addWatchDirs(w, s.dir); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("watch %s : %w", s.dir, err)
+	}
+
+	// a pending signal already covers the later events
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer w.Close()
+
+		t := time.NewTimer(debounce)
+		t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Create != 0 {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						if err = addWatchDirs(w, event.Name); err != nil {
+							zap.L().Error("watch config dir", zap.String("path", event.Name), zap.Error(err))
+						}
+					}
+				}
+				t.Reset(debounce)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				zap.L().Error("watch config dir", zap.String("path", s.dir), zap.Error(err))
+			case <-t.C:
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// addWatchDirs adds dir and all its subdirs to watcher, inotify is not recursive.
+func addWatchDirs(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		return w.Add(path)
+	})
+}
@@ -2,110 +2,574 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/metrics"
 )
 
 type Issuer interface {
 	Name() string
-	AddResource(config.Resources)
-	EnsureResource()
+	// AddResource registers new resources or updates existing ones, invalid resources are reported as failed.
+	AddResource(config.Resources) []Result
+	// RemoveResource retires resources.
+	RemoveResource(config.Resources) []Result
+	EnsureResource(r Resource, mode Mode) Result
+	// Rollback restores the previous certificate generation, the generation with the serial if it is set.
+	Rollback(r Resource, serial string) Result
+	// TokenExpiry returns expiry time of the issuer token, zero time if it does not expire.
+	TokenExpiry() time.Time
+	// Close stops the background work of the issuer such as token renewal.
+	Close()
 }
 
 type controller struct {
-	getConfig       func() (config.Config, error)
+	getConfig       func() ([]config.Change, error)
+	watchConfig     func(stop <-chan struct{}) (<-chan struct{}, error)
 	issuerConnector func(cfg config.Issuer) (Issuer, error)
 
-	issuer sync.Map
+	configInterval time.Duration
+
+	stop      chan struct{}
+	results   chan result
+	renews    chan renewRequest
+	rollbacks chan rollbackRequest
+
+	issuer    sync.Map
+	scheduler *scheduler
+
+	mu          sync.RWMutex
+	states      map[task]State
+	unavailable []string
+	issuerNames []string
+	heartbeat   time.Time
+	// jobs are start times of the running ensures
+	jobs map[task]time.Time
+
+	configs      map[string]config.Config
+	issuerConfig map[string]config.Issuer
+	resources    map[string]config.Resources
 }
 
 // New returns controller.
 func New(
-	config func() (config.Config, error),
+	getConfig func() ([]config.Change, error),
+	watchConfig func(stop <-chan struct{}) (<-chan struct{}, error),
 	issuerConnector func(cfg config.Issuer) (Issuer, error),
+	configInterval time.Duration,
+	ensureInterval time.Duration,
+	renewJitter time.Duration,
 ) *controller {
 	return &controller{
-		getConfig:       config,
+		getConfig:       getConfig,
+		watchConfig:     watchConfig,
 		issuerConnector: issuerConnector,
+		configInterval:  configInterval,
+		stop:            make(chan struct{}),
+		results:         make(chan result),
+		renews:          make(chan renewRequest),
+		rollbacks:       make(chan rollbackRequest),
+		scheduler:       newScheduler(ensureInterval, renewJitter),
+		configs:         make(map[string]config.Config),
+		issuerConfig:    make(map[string]config.Issuer),
+		resources:       make(map[string]config.Resources),
+		states:          make(map[task]State),
+		jobs:            make(map[task]time.Time),
 	}
 }
 
 // Start controller.
 func (s *controller) Start() error {
-	if err := s.getNewResource(); err != nil {
+	if err := s.refresh(); err != nil {
 		return err
 	}
 
-	// start getting new resources and issuers
-	go func() {
-		for range time.NewTicker(30 * time.Second).C {
-			if err := s.getNewResource(); err != nil {
+	events, err := s.watchConfig(s.stop)
+	if err != nil {
+		// the periodic rescan still picks up the changes
+		zap.L().Warn("watch_config", zap.Error(err))
+	}
+
+	go s.run(events)
+	return nil
+}
+
+// Stop controller.
+func (s *controller) Stop() {
+	close(s.stop)
+}
+
+// run handles config changes and ensures resources when their time comes.
+func (s *controller) run(events <-chan struct{}) {
+	configTicker := time.NewTicker(s.configInterval)
+	defer configTicker.Stop()
+
+	wakeup := time.NewTimer(0)
+	defer wakeup.Stop()
+
+	for {
+		s.beat()
+
+		select {
+		case <-s.stop:
+			return
+		case <-events:
+			// the loop is the only reader of the changes, so they are never applied out of order
+			if err := s.refresh(); err != nil {
+				zap.L().Error("refresh_resources", zap.Error(err))
+			}
+		case <-configTicker.C:
+			if err := s.refresh(); err != nil {
 				zap.L().Error("refresh_resources", zap.Error(err))
 			}
+		case req := <-s.renews:
+			req.reply <- s.scheduler.force(req.issuer, req.name, time.Now())
+		case req := <-s.rollbacks:
+			w, err := s.scheduler.rollback(req.task, req.serial, time.Now())
+			req.reply <- rollbackReply{waiter: w, err: err}
+		case r := <-s.results:
+			renewAt, next := s.scheduler.done(r)
+			s.setSchedule(r.task, renewAt, next)
+		case <-wakeup.C:
+			for _, j := range s.scheduler.due(time.Now()) {
+				go s.ensure(j)
+			}
+		}
+
+		if !wakeup.Stop() {
+			select {
+			case <-wakeup.C:
+			default:
+			}
 		}
-	}()
+		if next := s.scheduler.next(); !next.IsZero() {
+			wakeup.Reset(time.Until(next))
+		}
+	}
+}
+
+// RunOnce connects issuers, ensures every resource exactly once and returns error if anything failed.
+func (s *controller) RunOnce() error {
+	if err := s.refresh(); err != nil {
+		return err
+	}
 
-	// start resource ensure
-	go func() {
-		for range time.NewTicker(30 * time.Second).C {
-			s.issuer.Range(func(key, value any) bool {
-				issuer := value.(Issuer)
-				zap.L().Debug("start_ensure", zap.String("issuer", issuer.Name()))
-				issuer.EnsureResource()
-				zap.L().Debug("finish_ensure", zap.String("issuer", issuer.Name()))
-				return true
-			})
+	s.mu.RLock()
+	failed := append([]string(nil), s.unavailable...)
+	s.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, j := range s.scheduler.due(time.Now()) {
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			s.ensureJob(j)
+		}(j)
+	}
+	wg.Wait()
+
+	// states contain results of both registration and ensure
+	for _, st := range s.States() {
+		if st.Result.Err != nil {
+			failed = append(failed, st.Issuer+"/"+st.Result.Name)
 		}
-	}()
+	}
 
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("%w: %s", errEnsureFailed, strings.Join(failed, ", "))
+	}
 	return nil
 }
 
-func (s *controller) getNewResource() error {
-	cfg, err := s.getConfig()
-	if err != nil {
-		return fmt.Errorf("get new configs: %w", err)
+type renewRequest struct {
+	issuer string
+	name   string
+	reply  chan map[task]chan Result
+}
+
+// RenewResult is a result of the forced certificate renewal.
+type RenewResult struct {
+	Issuer string
+	Result Result
+}
+
+// Renew reissues the certificate immediately regardless of its expiry and waits for the result.
+// Empty name renews all certificates of the issuer, empty issuer and name renew all certificates.
+func (s *controller) Renew(issuer, name string) ([]RenewResult, error) {
+	req := renewRequest{
+		issuer: issuer,
+		name:   name,
+		reply:  make(chan map[task]chan Result, 1),
+	}
+	select {
+	case s.renews <- req:
+	case <-s.stop:
+		return nil, errIsStopped
+	}
+	waiters := <-req.reply
+	if len(waiters) == 0 {
+		return nil, errCertificateIsNotExist
 	}
 
-	for _, issuer := range cfg.Issuers {
-		_, isExist := s.issuer.Load(issuer.Name)
-		if isExist {
+	r := make([]RenewResult, 0, len(waiters))
+	for t, w := range waiters {
+		select {
+		case res := <-w:
+			r = append(r, RenewResult{Issuer: t.issuer, Result: res})
+		case <-s.stop:
+			return nil, errIsStopped
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Issuer != r[j].Issuer {
+			return r[i].Issuer < r[j].Issuer
+		}
+		return r[i].Result.Name < r[j].Result.Name
+	})
+	return r, nil
+}
+
+type rollbackRequest struct {
+	task   task
+	serial string
+	reply  chan rollbackReply
+}
+
+type rollbackReply struct {
+	waiter chan Result
+	err    error
+}
+
+// Rollback restores the previous generation of the issuer certificate, the generation with the serial if it is set,
+// and waits for the result.
+func (s *controller) Rollback(issuer, name, serial string) (Result, error) {
+	req := rollbackRequest{
+		task:   task{issuer: issuer, resource: Resource{Kind: KindCertificate, Name: name}},
+		serial: serial,
+		reply:  make(chan rollbackReply, 1),
+	}
+	select {
+	case s.rollbacks <- req:
+	case <-s.stop:
+		return Result{}, errIsStopped
+	}
+	reply := <-req.reply
+	if reply.err != nil {
+		return Result{}, reply.err
+	}
+
+	select {
+	case r := <-reply.waiter:
+		return r, nil
+	case <-s.stop:
+		return Result{}, errIsStopped
+	}
+}
+
+func (s *controller) ensure(j job) {
+	r := s.ensureJob(j)
+
+	select {
+	case s.results <- r:
+	case <-s.stop:
+	}
+}
+
+func (s *controller) ensureJob(j job) result {
+	r := result{job: j}
+	defer s.startJob(j.task)()
+
+	issuer, isExist := s.issuer.Load(j.issuer)
+	switch {
+	case isExist && j.mode == Rollback:
+		r.Result = issuer.(Issuer).Rollback(j.resource, j.serial)
+		if r.Err != nil {
+			// the state of the certificate is refreshed by the check which follows the rollback
 			zap.L().Error(
-				"issuer_connect",
-				zap.String("issuer_name", issuer.Name),
-				zap.String("status", "failed"),
-				zap.Error(errIssuerIsExist),
+				"rollback",
+				zap.String("issuer_name", j.issuer),
+				zap.String("resource_type", j.resource.Kind),
+				zap.String("name", j.resource.Name),
+				zap.Error(r.Err),
 			)
+			return r
+		}
+	case isExist:
+		r.Result = issuer.(Issuer).EnsureResource(j.resource, j.mode)
+	default:
+		r.Result = Result{Resource: j.resource, Action: ActionFailed, Err: errIssuerIsNotExist}
+	}
+	s.handleResult(j.issuer, r.Result, true)
+	return r
+}
+
+// handleResult logs the result and stores it as the resource state.
+func (s *controller) handleResult(issuer string, r Result, isEnsure bool) {
+	logger := zap.L().With(
+		zap.String("issuer_name", issuer),
+		zap.String("resource_type", r.Kind),
+		zap.String("name", r.Name),
+		zap.String("action", string(r.Action)),
+	)
+	if !r.NotAfter.IsZero() {
+		logger = logger.With(zap.Time("not_after", r.NotAfter))
+	}
+
+	switch {
+	case r.Err != nil:
+		logger.Error("ensure", zap.Error(r.Err))
+	case r.Action == ActionNone:
+		logger.Debug("ensure")
+	default:
+		logger.Info("ensure")
+	}
+
+	switch r.Action {
+	case ActionIssued, ActionRenewed:
+		metrics.IssuanceTotal.WithLabelValues(issuer, r.Kind, r.Name, metrics.Success).Inc()
+	case ActionFailed:
+		metrics.IssuanceTotal.WithLabelValues(issuer, r.Kind, r.Name, metrics.Failure).Inc()
+	}
+	if r.Kind == KindCertificate && !r.NotAfter.IsZero() {
+		metrics.CertificateExpiry.WithLabelValues(issuer, r.Name).Set(float64(r.NotAfter.Unix()))
+	}
+
+	if r.Action == ActionRetired {
+		if r.Kind == KindCertificate {
+			metrics.DeleteCertificate(issuer, r.Name)
+		}
+		s.deleteState(task{issuer: issuer, resource: r.Resource})
+		return
+	}
+	s.setState(issuer, r, isEnsure)
+}
+
+func (s *controller) refresh() error {
+	changes, err := s.getConfig()
+	if err != nil {
+		metrics.ConfigReloadTotal.WithLabelValues(metrics.Failure).Inc()
+		return fmt.Errorf("get config changes: %w", err)
+	}
+	s.apply(changes)
+	return nil
+}
+
+func (s *controller) apply(changes []config.Change) {
+	if len(changes) != 0 {
+		metrics.ConfigReloadTotal.WithLabelValues(metrics.Success).Inc()
+	}
+
+	for _, c := range changes {
+		if c.Op == config.Remove {
+			delete(s.configs, c.Path)
+		} else {
+			s.configs[c.Path] = c.Config
+		}
+		zap.L().Info("config_change", zap.String("path", c.Path), zap.Stringer("op", c.Op))
+	}
+
+	// reconcile on every refresh to retry the failed issuer connections
+	s.reconcile()
+}
+
+// reconcile brings issuers and their resources in line with the current configs.
+func (s *controller) reconcile() {
+	issuers, resources := s.desiredState()
+
+	for name, cfg := range s.issuerConfig {
+		newCfg, isExist := issuers[name]
+		if isExist && reflect.DeepEqual(cfg, newCfg) {
 			continue
 		}
+		s.disconnectIssuer(name, resources, isExist)
+	}
 
-		conn, err := s.issuerConnector(issuer)
+	for name, cfg := range issuers {
+		if _, isExist := s.issuerConfig[name]; isExist {
+			continue
+		}
+
+		conn, err := s.issuerConnector(cfg)
+		// a connect is bounded by the client timeouts, the loop beats after each one
+		// to stay healthy while it waits for an unavailable Vault
+		s.beat()
 		if err != nil {
-			zap.L().Error("issuer_connect", zap.String("issuer_name", issuer.Name), zap.Error(err))
+			zap.L().Error("issuer_connect", zap.String("issuer_name", name), zap.Error(err))
 			continue
 		}
 
-		s.issuer.Store(issuer.Name, conn)
+		s.issuer.Store(name, conn)
+		s.issuerConfig[name] = cfg
 
-		zap.L().Debug("issuer_connect", zap.String("issuer_name", issuer.Name))
+		zap.L().Debug("issuer_connect", zap.String("issuer_name", name))
 	}
 
-	resources := s.separateResourcesByIssuers(cfg.Resource)
 	for issuerName, rCfg := range resources {
 		issuer, isExist := s.issuer.Load(issuerName)
 		if !isExist {
 			zap.L().Error("add_resource", zap.String("issuer_name", issuerName), zap.Error(errIssuerIsNotExist))
 			continue
 		}
-		issuer.(Issuer).AddResource(rCfg)
 
-		zap.L().Debug("add_resource", zap.String("issuer_name", issuerName))
+		upsert, remove := diffResources(s.resources[issuerName], rCfg)
+		if !isEmpty(remove) {
+			s.unschedule(issuerName, remove)
+			s.removeResources(issuerName, issuer.(Issuer), remove, resources)
+		}
+		if !isEmpty(upsert) {
+			s.schedule(issuerName, upsert)
+			s.handleResults(issuerName, issuer.(Issuer).AddResource(upsert))
+			zap.L().Debug("add_resource", zap.String("issuer_name", issuerName))
+		}
+		s.resources[issuerName] = rCfg
+	}
+
+	for issuerName, rCfg := range s.resources {
+		if _, isExist := resources[issuerName]; isExist {
+			continue
+		}
+		s.unschedule(issuerName, rCfg)
+		if issuer, isExist := s.issuer.Load(issuerName); isExist {
+			s.removeResources(issuerName, issuer.(Issuer), rCfg, resources)
+		}
+		delete(s.resources, issuerName)
+	}
+
+	s.setUnavailable(issuers, resources)
+}
+
+// disconnectIssuer drops the issuer connection.
+// The resources which are not in the config anymore are retired by the old connection,
+// the rest are added again to a new connection if the issuer is still configured.
+func (s *controller) disconnectIssuer(name string, resources map[string]config.Resources, isConfigured bool) {
+	if issuer, isExist := s.issuer.Load(name); isExist {
+		_, remove := diffResources(s.resources[name], resources[name])
+		if !isEmpty(remove) {
+			s.unschedule(name, remove)
+			s.removeResources(name, issuer.(Issuer), remove, resources)
+		}
+		// jobs which are running keep working with the closed issuer and fail,
+		// their results are dropped as the tasks are removed below
+		issuer.(Issuer).Close()
+	}
+
+	s.issuer.Delete(name)
+	s.scheduler.removeIssuer(name)
+	s.deleteIssuerStates(name)
+	metrics.DeleteIssuer(name)
+	delete(s.issuerConfig, name)
+	delete(s.resources, name)
+
+	zap.L().Info("issuer_disconnect", zap.String("issuer_name", name), zap.Bool("reconnect", isConfigured))
+}
+
+// removeResources retires resources removed from the issuer.
+// A resource which is still desired under another issuer with the same name and hostPath,
+// e.g. after issuerRef change, is only forgotten by the issuer, its files are not retired.
+func (s *controller) removeResources(issuerName string, issuer Issuer, remove config.Resources, resources map[string]config.Resources) {
+	retire, moved := splitMoved(issuerName, remove, resources)
+	for _, cert := range moved.Certificates {
+		s.forget(issuerName, Resource{Kind: KindCertificate, Name: cert.Name})
+	}
+	for _, secret := range moved.Secrets {
+		s.forget(issuerName, Resource{Kind: KindSecret, Name: secret.Name})
+	}
+	// resources are retired one by one with the heartbeat after each one,
+	// the revocation of every certificate may wait for Vault up to the client timeout
+	for _, cert := range retire.Certificates {
+		s.handleResults(issuerName, issuer.RemoveResource(config.Resources{Certificates: []config.Certificate{cert}}))
+		s.beat()
+	}
+	for _, secret := range retire.Secrets {
+		s.handleResults(issuerName, issuer.RemoveResource(config.Resources{Secrets: []config.Secret{secret}}))
+		s.beat()
+	}
+	if !isEmpty(retire) {
+		zap.L().Debug("remove_resource", zap.String("issuer_name", issuerName))
 	}
-	return nil
+}
+
+// forget drops the state of the resource moved to another issuer.
+func (s *controller) forget(issuerName string, r Resource) {
+	if r.Kind == KindCertificate {
+		metrics.DeleteCertificate(issuerName, r.Name)
+	}
+	s.deleteState(task{issuer: issuerName, resource: r})
+	zap.L().Info(
+		"move_resource",
+		zap.String("issuer_name", issuerName),
+		zap.String("resource_type", r.Kind),
+		zap.String("name", r.Name),
+	)
+}
+
+// handleResults handles results of resource registration and retirement.
+// A resource failed on registration is not scheduled.
+func (s *controller) handleResults(issuerName string, results []Result) {
+	for _, r := range results {
+		if r.Action == ActionFailed {
+			s.scheduler.remove(task{issuer: issuerName, resource: r.Resource})
+		}
+		s.handleResult(issuerName, r, false)
+	}
+}
+
+func (s *controller) schedule(issuerName string, r config.Resources) {
+	for _, cert := range r.Certificates {
+		s.scheduler.add(task{issuer: issuerName, resource: Resource{Kind: KindCertificate, Name: cert.Name}}, cert.RenewBefore, cert.CheckInterval)
+	}
+	for _, secret := range r.Secrets {
+		s.scheduler.add(task{issuer: issuerName, resource: Resource{Kind: KindSecret, Name: secret.Name}}, 0, secret.CheckInterval)
+	}
+}
+
+func (s *controller) unschedule(issuerName string, r config.Resources) {
+	for _, cert := range r.Certificates {
+		s.scheduler.remove(task{issuer: issuerName, resource: Resource{Kind: KindCertificate, Name: cert.Name}})
+	}
+	for _, secret := range r.Secrets {
+		s.scheduler.remove(task{issuer: issuerName, resource: Resource{Kind: KindSecret, Name: secret.Name}})
+	}
+}
+
+// desiredState collects issuers and resources from all config files.
+func (s *controller) desiredState() (map[string]config.Issuer, map[string]config.Resources) {
+	paths := make([]string, 0, len(s.configs))
+	for path := range s.configs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var (
+		issuers = make(map[string]config.Issuer)
+		all     config.Resources
+	)
+	for _, path := range paths {
+		cfg := s.configs[path]
+		for _, issuer := range cfg.Issuers {
+			if _, isExist := issuers[issuer.Name]; isExist {
+				zap.L().Error(
+					"issuer_connect",
+					zap.String("issuer_name", issuer.Name),
+					zap.String("path", path),
+					zap.String("status", "failed"),
+					zap.Error(errIssuerIsExist),
+				)
+				continue
+			}
+			issuers[issuer.Name] = issuer
+		}
+		all.Certificates = append(all.Certificates, cfg.Resource.Certificates...)
+		all.Secrets = append(all.Secrets, cfg.Resource.Secrets...)
+	}
+	return issuers, s.separateResourcesByIssuers(all)
 }
 
 func (s *controller) separateResourcesByIssuers(cfg config.Resources) map[string]config.Resources {
@@ -124,3 +588,85 @@ func (s *controller) separateResourcesByIssuers(cfg config.Resources) map[string
 	}
 	return r
 }
+
+// diffResources returns resources which are added or changed in desired and resources which are absent in desired.
+func diffResources(old, desired config.Resources) (upsert, remove config.Resources) {
+	oldCerts := make(map[string]config.Certificate, len(old.Certificates))
+	for _, cert := range old.Certificates {
+		oldCerts[cert.Name] = cert
+	}
+	newCerts := make(map[string]struct{}, len(desired.Certificates))
+	for _, cert := range desired.Certificates {
+		newCerts[cert.Name] = struct{}{}
+		if oldCert, isExist := oldCerts[cert.Name]; !isExist || !reflect.DeepEqual(oldCert, cert) {
+			upsert.Certificates = append(upsert.Certificates, cert)
+		}
+	}
+	for _, cert := range old.Certificates {
+		if _, isExist := newCerts[cert.Name]; !isExist {
+			remove.Certificates = append(remove.Certificates, cert)
+		}
+	}
+
+	oldSecrets := make(map[string]config.Secret, len(old.Secrets))
+	for _, secret := range old.Secrets {
+		oldSecrets[secret.Name] = secret
+	}
+	newSecrets := make(map[string]struct{}, len(desired.Secrets))
+	for _, secret := range desired.Secrets {
+		newSecrets[secret.Name] = struct{}{}
+		if oldSecret, isExist := oldSecrets[secret.Name]; !isExist || !reflect.DeepEqual(oldSecret, secret) {
+			upsert.Secrets = append(upsert.Secrets, secret)
+		}
+	}
+	for _, secret := range old.Secrets {
+		if _, isExist := newSecrets[secret.Name]; !isExist {
+			remove.Secrets = append(remove.Secrets, secret)
+		}
+	}
+	return
+}
+
+// resourceFiles identifies files of a resource regardless of its issuer.
+type resourceFiles struct {
+	kind     string
+	name     string
+	hostPath string
+}
+
+// splitMoved separates resources removed from the issuer into the ones to retire
+// and the ones which are still desired under another issuer with the same name and hostPath.
+func splitMoved(issuerName string, remove config.Resources, resources map[string]config.Resources) (retire, moved config.Resources) {
+	desired := make(map[resourceFiles]struct{})
+	for name, r := range resources {
+		if name == issuerName {
+			continue
+		}
+		for _, cert := range r.Certificates {
+			desired[resourceFiles{kind: KindCertificate, name: cert.Name, hostPath: cert.HostPath}] = struct{}{}
+		}
+		for _, secret := range r.Secrets {
+			desired[resourceFiles{kind: KindSecret, name: secret.Name, hostPath: secret.HostPath}] = struct{}{}
+		}
+	}
+
+	for _, cert := range remove.Certificates {
+		if _, isExist := desired[resourceFiles{kind: KindCertificate, name: cert.Name, hostPath: cert.HostPath}]; isExist {
+			moved.Certificates = append(moved.Certificates, cert)
+		} else {
+			retire.Certificates = append(retire.Certificates, cert)
+		}
+	}
+	for _, secret := range remove.Secrets {
+		if _, isExist := desired[resourceFiles{kind: KindSecret, name: secret.Name, hostPath: secret.HostPath}]; isExist {
+			moved.Secrets = append(moved.Secrets, secret)
+		} else {
+			retire.Secrets = append(retire.Secrets, secret)
+		}
+	}
+	return
+}
+
+func isEmpty(r config.Resources) bool {
+	return len(r.Certificates) == 0 && len(r.Secrets) == 0
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+func TestDiffResources(t *testing.T) {
+	var (
+		certA  = config.Certificate{Name: "a", HostPath: "/etc/a"}
+		certA2 = config.Certificate{Name: "a", HostPath: "/etc/a", RenewBefore: time.Hour}
+		certB  = config.Certificate{Name: "b", HostPath: "/etc/b"}
+		secret = config.Secret{Name: "s", HostPath: "/etc/s"}
+	)
+
+	tests := []struct {
+		name       string
+		old        config.Resources
+		desired    config.Resources
+		wantUpsert config.Resources
+		wantRemove config.Resources
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:       "added",
+			desired:    config.Resources{Certificates: []config.Certificate{certA}, Secrets: []config.Secret{secret}},
+			wantUpsert: config.Resources{Certificates: []config.Certificate{certA}, Secrets: []config.Secret{secret}},
+		},
+		{
+			name:    "unchanged",
+			old:     config.Resources{Certificates: []config.Certificate{certA}, Secrets: []config.Secret{secret}},
+			desired: config.Resources{Certificates: []config.Certificate{certA}, Secrets: []config.Secret{secret}},
+		},
+		{
+			name:       "changed",
+			old:        config.Resources{Certificates: []config.Certificate{certA, certB}},
+			desired:    config.Resources{Certificates: []config.Certificate{certA2, certB}},
+			wantUpsert: config.Resources{Certificates: []config.Certificate{certA2}},
+		},
+		{
+			name:       "removed",
+			old:        config.Resources{Certificates: []config.Certificate{certA, certB}, Secrets: []config.Secret{secret}},
+			desired:    config.Resources{Certificates: []config.Certificate{certB}},
+			wantRemove: config.Resources{Certificates: []config.Certificate{certA}, Secrets: []config.Secret{secret}},
+		},
+		{
+			name:       "replaced",
+			old:        config.Resources{Certificates: []config.Certificate{certA}},
+			desired:    config.Resources{Certificates: []config.Certificate{certB}},
+			wantUpsert: config.Resources{Certificates: []config.Certificate{certB}},
+			wantRemove: config.Resources{Certificates: []config.Certificate{certA}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upsert, remove := diffResources(tt.old, tt.desired)
+			assert.Equal(t, tt.wantUpsert, upsert)
+			assert.Equal(t, tt.wantRemove, remove)
+		})
+	}
+}
+
+func TestSplitMoved(t *testing.T) {
+	var (
+		cert      = config.Certificate{Name: "a", HostPath: "/etc/a"}
+		certOther = config.Certificate{Name: "a", HostPath: "/etc/other"}
+		secret    = config.Secret{Name: "s", HostPath: "/etc/s"}
+		remove    = config.Resources{Certificates: []config.Certificate{cert}, Secrets: []config.Secret{secret}}
+	)
+
+	tests := []struct {
+		name       string
+		resources  map[string]config.Resources
+		wantRetire config.Resources
+		wantMoved  config.Resources
+	}{
+		{
+			name:       "removed from config",
+			resources:  map[string]config.Resources{},
+			wantRetire: remove,
+		},
+		{
+			name: "moved to another issuer",
+			resources: map[string]config.Resources{
+				"b": {Certificates: []config.Certificate{cert}, Secrets: []config.Secret{secret}},
+			},
+			wantMoved: remove,
+		},
+		{
+			name: "moved with another hostPath",
+			resources: map[string]config.Resources{
+				"b": {Certificates: []config.Certificate{certOther}},
+			},
+			wantRetire: remove,
+		},
+		{
+			name: "still desired by the same issuer",
+			resources: map[string]config.Resources{
+				"a": {Certificates: []config.Certificate{cert}},
+				"b": {Secrets: []config.Secret{secret}},
+			},
+			wantRetire: config.Resources{Certificates: []config.Certificate{cert}},
+			wantMoved:  config.Resources{Secrets: []config.Secret{secret}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retire, moved := splitMoved("a", remove, tt.resources)
+			assert.Equal(t, tt.wantRetire, retire)
+			assert.Equal(t, tt.wantMoved, moved)
+		})
+	}
+}
+
+// fakeIssuer records the resources registered and retired by the controller.
+type fakeIssuer struct {
+	cfg         config.Issuer
+	added       []config.Resources
+	removed     []config.Resources
+	closed      bool
+	tokenExpiry time.Time
+}
+
+func (f *fakeIssuer) Name() string { return f.cfg.Name }
+
+func (f *fakeIssuer) AddResource(r config.Resources) []Result {
+	f.added = append(f.added, r)
+	return nil
+}
+
+func (f *fakeIssuer) RemoveResource(r config.Resources) []Result {
+	f.removed = append(f.removed, r)
+	return nil
+}
+
+func (f *fakeIssuer) EnsureResource(r Resource, _ Mode) Result { return Result{Resource: r} }
+
+func (f *fakeIssuer) Rollback(r Resource, _ string) Result { return Result{Resource: r} }
+
+func (f *fakeIssuer) TokenExpiry() time.Time { return f.tokenExpiry }
+
+func (f *fakeIssuer) Close() { f.closed = true }
+
+func TestReconcileIssuerChange(t *testing.T) {
+	var (
+		issuer  = config.Issuer{Name: "a", Vault: config.Vault{Resource: config.Resource{Role: "r1"}}}
+		changed = config.Issuer{Name: "a", Vault: config.Vault{Resource: config.Resource{Role: "r2"}}}
+		ref     = config.IssuerRef{Name: "a"}
+		certA   = config.Certificate{Name: "a", IssuerRef: ref, HostPath: "/etc/a"}
+		certB   = config.Certificate{Name: "b", IssuerRef: ref, HostPath: "/etc/b"}
+		secret  = config.Secret{Name: "s", IssuerRef: ref, HostPath: "/etc/s"}
+	)
+
+	var connected []*fakeIssuer
+	c := New(nil, nil, func(cfg config.Issuer) (Issuer, error) {
+		f := &fakeIssuer{cfg: cfg}
+		connected = append(connected, f)
+		return f, nil
+	}, time.Minute, time.Minute, 0)
+
+	c.apply([]config.Change{{Op: config.Create, Path: "a.conf", Config: config.Config{
+		Issuers:  []config.Issuer{issuer},
+		Resource: config.Resources{Certificates: []config.Certificate{certA, certB}, Secrets: []config.Secret{secret}},
+	}}})
+	require.Len(t, connected, 1)
+
+	// the issuer and the resources are changed by a single edit
+	c.apply([]config.Change{{Op: config.Write, Path: "a.conf", Config: config.Config{
+		Issuers:  []config.Issuer{changed},
+		Resource: config.Resources{Certificates: []config.Certificate{certA}},
+	}}})
+	require.Len(t, connected, 2)
+
+	old, reconnected := connected[0], connected[1]
+	assert.True(t, old.closed)
+	assert.Equal(t, []config.Resources{{Certificates: []config.Certificate{certB}}, {Secrets: []config.Secret{secret}}}, old.removed)
+	assert.Equal(t, changed, reconnected.cfg)
+	assert.Equal(t, []config.Resources{{Certificates: []config.Certificate{certA}}}, reconnected.added)
+	assert.Empty(t, reconnected.removed)
+
+	_, isScheduled := c.scheduler.tasks[task{issuer: "a", resource: Resource{Kind: KindCertificate, Name: "b"}}]
+	assert.False(t, isScheduled)
+	_, isScheduled = c.scheduler.tasks[task{issuer: "a", resource: Resource{Kind: KindCertificate, Name: "a"}}]
+	assert.True(t, isScheduled)
+}
@@ -3,6 +3,15 @@ package controller
 import "errors"
 
 var (
-	errIssuerIsExist    = errors.New("issuer is exist")
-	errIssuerIsNotExist = errors.New("issuer is not exist")
+	errIssuerIsExist         = errors.New("issuer is exist")
+	errIssuerIsNotExist      = errors.New("issuer is not exist")
+	errEnsureFailed          = errors.New("ensure failed")
+	errNotReady              = errors.New("not ready")
+	errLoopIsStalled         = errors.New("loop is stalled")
+	errJobIsStuck            = errors.New("ensure is stuck")
+	errTokenIsExpired        = errors.New("issuer token is expired")
+	errResourceIsRemoved     = errors.New("resource is removed")
+	errCertificateIsNotExist = errors.New("certificate is not exist")
+	errIsStopped             = errors.New("controller is stopped")
+	errRollbackIsPending     = errors.New("rollback is pending")
 )
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+// minStallTimeout is the lower bound of time without loop heartbeat after which the loop is considered stalled.
+const minStallTimeout = time.Minute
+
+// jobTimeout is the time after which a running ensure is considered stuck.
+// Issuer calls and triggers are bounded by their own timeouts, so an ensure running longer hangs
+// and the resource is never ensured again until restart.
+const jobTimeout = 15 * time.Minute
+
+// Ready returns error until every configured issuer is connected
+// and every resource is successfully ensured at least once.
+func (s *controller) Ready() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pending := append([]string(nil), s.unavailable...)
+	for t, st := range s.states {
+		if st.EnsuredAt.IsZero() {
+			pending = append(pending, t.issuer+"/"+t.resource.Name)
+		}
+	}
+
+	if len(pending) != 0 {
+		sort.Strings(pending)
+		return fmt.Errorf("%w: %s", errNotReady, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Healthy returns error if the controller loop is stalled, an ensure is stuck or the token of any issuer has expired.
+func (s *controller) Healthy() error {
+	s.mu.RLock()
+	heartbeat := s.heartbeat
+	var stuck []string
+	for t, startedAt := range s.jobs {
+		if time.Since(startedAt) > jobTimeout {
+			stuck = append(stuck, t.issuer+"/"+t.resource.Name)
+		}
+	}
+	s.mu.RUnlock()
+
+	stallTimeout := 3 * s.configInterval
+	if stallTimeout < minStallTimeout {
+		stallTimeout = minStallTimeout
+	}
+	if !heartbeat.IsZero() && time.Since(heartbeat) > stallTimeout {
+		return fmt.Errorf("%w: last heartbeat %s ago", errLoopIsStalled, time.Since(heartbeat).Round(time.Second))
+	}
+	if len(stuck) != 0 {
+		sort.Strings(stuck)
+		return fmt.Errorf("%w: %s", errJobIsStuck, strings.Join(stuck, ", "))
+	}
+
+	var expired []string
+	s.issuer.Range(func(key, value any) bool {
+		if expiry := value.(Issuer).TokenExpiry(); !expiry.IsZero() && time.Now().After(expiry) {
+			expired = append(expired, key.(string))
+		}
+		return true
+	})
+	if len(expired) != 0 {
+		sort.Strings(expired)
+		return fmt.Errorf("%w: %s", errTokenIsExpired, strings.Join(expired, ", "))
+	}
+	return nil
+}
+
+func (s *controller) beat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heartbeat = time.Now()
+}
+
+// startJob remembers the start of the ensure and returns the func which forgets it.
+func (s *controller) startJob(t task) func() {
+	startedAt := time.Now()
+	s.mu.Lock()
+	s.jobs[t] = startedAt
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// the task might be removed and started again while the job hung
+		if s.jobs[t].Equal(startedAt) {
+			delete(s.jobs, t)
+		}
+	}
+}
+
+// setUnavailable remembers configured issuers, issuers which are not connected and resources of such issuers.
+func (s *controller) setUnavailable(issuers map[string]config.Issuer, resources map[string]config.Resources) {
+	var unavailable []string
+	names := make([]string, 0, len(issuers))
+	for name := range issuers {
+		names = append(names, name)
+		if _, isExist := s.issuerConfig[name]; !isExist {
+			unavailable = append(unavailable, "issuer "+name)
+		}
+	}
+	sort.Strings(names)
+	for issuerName, r := range resources {
+		if _, isExist := s.issuer.Load(issuerName); isExist {
+			continue
+		}
+		for _, cert := range r.Certificates {
+			unavailable = append(unavailable, issuerName+"/"+cert.Name)
+		}
+		for _, secret := range r.Secrets {
+			unavailable = append(unavailable, issuerName+"/"+secret.Name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = unavailable
+	s.issuerNames = names
+}
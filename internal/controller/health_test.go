@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+func TestHealthy(t *testing.T) {
+	var (
+		now  = time.Now()
+		cert = task{issuer: "a", resource: Resource{Kind: KindCertificate, Name: "a"}}
+	)
+
+	tests := []struct {
+		name        string
+		heartbeat   time.Time
+		jobs        map[task]time.Time
+		tokenExpiry time.Time
+		wantErr     error
+	}{
+		{
+			name:      "healthy",
+			heartbeat: now,
+			jobs:      map[task]time.Time{cert: now.Add(-time.Minute)},
+		},
+		{
+			name: "loop is not started",
+		},
+		{
+			name:      "loop is stalled",
+			heartbeat: now.Add(-2 * minStallTimeout),
+			wantErr:   errLoopIsStalled,
+		},
+		{
+			name:      "ensure is stuck",
+			heartbeat: now,
+			jobs:      map[task]time.Time{cert: now.Add(-jobTimeout - time.Minute)},
+			wantErr:   errJobIsStuck,
+		},
+		{
+			name:        "token is expired",
+			heartbeat:   now,
+			tokenExpiry: now.Add(-time.Minute),
+			wantErr:     errTokenIsExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(nil, nil, nil, time.Second, time.Minute, 0)
+			c.heartbeat = tt.heartbeat
+			for j, startedAt := range tt.jobs {
+				c.jobs[j] = startedAt
+			}
+			c.issuer.Store("a", &fakeIssuer{cfg: config.Issuer{Name: "a"}, tokenExpiry: tt.tokenExpiry})
+
+			err := c.Healthy()
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestEnsureJobIsTracked(t *testing.T) {
+	var (
+		cert   = task{issuer: "a", resource: Resource{Kind: KindCertificate, Name: "a"}}
+		c      = New(nil, nil, nil, time.Second, time.Minute, 0)
+		issuer = &blockingIssuer{fakeIssuer: fakeIssuer{cfg: config.Issuer{Name: "a"}}, release: make(chan struct{})}
+		done   = make(chan struct{})
+	)
+	c.issuer.Store("a", issuer)
+
+	go func() {
+		defer close(done)
+		c.ensureJob(job{task: cert})
+	}()
+	require.Eventually(t, func() bool {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		_, isRunning := c.jobs[cert]
+		return isRunning
+	}, time.Second, time.Millisecond)
+
+	close(issuer.release)
+	<-done
+	assert.Empty(t, c.jobs)
+}
+
+// blockingIssuer holds ensures until release is closed.
+type blockingIssuer struct {
+	fakeIssuer
+	release chan struct{}
+}
+
+func (b *blockingIssuer) EnsureResource(r Resource, _ Mode) Result {
+	<-b.release
+	return Result{Resource: r}
+}
+
+func TestReconcileBeats(t *testing.T) {
+	var (
+		ref    = config.IssuerRef{Name: "a"}
+		issuer = config.Issuer{Name: "a"}
+		cert   = config.Certificate{Name: "a", IssuerRef: ref, HostPath: "/etc/a"}
+		other  = config.Issuer{Name: "b"}
+		stale  = time.Now().Add(-time.Hour)
+	)
+	c := New(nil, nil, func(cfg config.Issuer) (Issuer, error) {
+		if cfg.Name == other.Name {
+			return nil, errors.New("vault is unavailable")
+		}
+		return &fakeIssuer{cfg: cfg}, nil
+	}, time.Second, time.Minute, 0)
+
+	c.heartbeat = stale
+	c.apply([]config.Change{{Op: config.Create, Path: "a.conf", Config: config.Config{
+		Issuers:  []config.Issuer{issuer, other},
+		Resource: config.Resources{Certificates: []config.Certificate{cert}},
+	}}})
+	// a failed connect does not stall the loop
+	assert.True(t, c.heartbeat.After(stale))
+
+	c.heartbeat = stale
+	c.apply([]config.Change{{Op: config.Write, Path: "a.conf", Config: config.Config{
+		Issuers: []config.Issuer{issuer},
+	}}})
+	// nor does the retirement
+	assert.True(t, c.heartbeat.After(stale))
+}
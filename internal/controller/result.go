@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"sort"
+	"time"
+)
+
+// Action taken by issuer on resource.
+type Action string
+
+const (
+	ActionNone    Action = "none"
+	ActionIssued  Action = "issued"
+	ActionRenewed Action = "renewed"
+	ActionRetired Action = "retired"
+	// ActionRolledBack means the previous certificate generation was restored.
+	ActionRolledBack Action = "rolled_back"
+	ActionFailed     Action = "failed"
+)
+
+// Result of the issuer operation on resource.
+// NotBefore, NotAfter and Serial are set for certificates only.
+type Result struct {
+	Resource
+	Action    Action
+	Path      string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	Err       error
+}
+
+// State is the last known state of resource.
+// EnsuredAt is a time of the last successful ensure, zero if resource was never ensured.
+type State struct {
+	Issuer    string
+	Result    Result
+	CheckedAt time.Time
+	EnsuredAt time.Time
+	RenewAt   time.Time
+	NextCheck time.Time
+}
+
+// IssuerStatus is a state of configured issuer.
+type IssuerStatus struct {
+	Name        string
+	Connected   bool
+	TokenExpiry time.Time
+}
+
+// Status of issuers and resources.
+type Status struct {
+	Issuers   []IssuerStatus
+	Resources []State
+}
+
+// Status returns the current status of issuers and resources.
+func (s *controller) Status() Status {
+	s.mu.RLock()
+	issuers := make([]IssuerStatus, 0, len(s.issuerNames))
+	for _, name := range s.issuerNames {
+		issuers = append(issuers, IssuerStatus{Name: name})
+	}
+	s.mu.RUnlock()
+
+	for i := range issuers {
+		if issuer, isExist := s.issuer.Load(issuers[i].Name); isExist {
+			issuers[i].Connected = true
+			issuers[i].TokenExpiry = issuer.(Issuer).TokenExpiry()
+		}
+	}
+
+	return Status{
+		Issuers:   issuers,
+		Resources: s.States(),
+	}
+}
+
+// States returns the last known states of all resources.
+func (s *controller) States() []State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]State, 0, len(s.states))
+	for _, st := range s.states {
+		states = append(states, st)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Issuer != states[j].Issuer {
+			return states[i].Issuer < states[j].Issuer
+		}
+		if states[i].Result.Kind != states[j].Result.Kind {
+			return states[i].Result.Kind < states[j].Result.Kind
+		}
+		return states[i].Result.Name < states[j].Result.Name
+	})
+	return states
+}
+
+func (s *controller) setState(issuer string, r Result, isEnsure bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := task{issuer: issuer, resource: r.Resource}
+	old := s.states[t]
+	st := State{
+		Issuer:    issuer,
+		Result:    r,
+		CheckedAt: time.Now(),
+		EnsuredAt: old.EnsuredAt,
+		RenewAt:   old.RenewAt,
+		NextCheck: old.NextCheck,
+	}
+	if isEnsure && r.Err == nil {
+		st.EnsuredAt = st.CheckedAt
+	}
+	s.states[t] = st
+}
+
+func (s *controller) setSchedule(t task, renewAt, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, isExist := s.states[t]
+	if !isExist {
+		return
+	}
+	st.RenewAt, st.NextCheck = renewAt, next
+	s.states[t] = st
+}
+
+func (s *controller) deleteState(t task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, t)
+}
+
+func (s *controller) deleteIssuerStates(issuer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for t := range s.states {
+		if t.issuer == issuer {
+			delete(s.states, t)
+		}
+	}
+}
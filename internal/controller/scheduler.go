@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fraima/key-keeper/internal/metrics"
+)
+
+// Resource kinds.
+const (
+	KindCertificate = "certificate"
+	KindSecret      = "secret"
+)
+
+// Mode of resource ensure.
+type Mode int
+
+const (
+	// Check reissues resource if it is missing or expires within renewBefore.
+	Check Mode = iota
+	// Renew reissues certificate because its planned renewal time has come.
+	Renew
+	// Force reissues certificate on demand of operator regardless of its expiry.
+	Force
+	// Rollback restores the previous certificate generation on demand of operator.
+	Rollback
+)
+
+// Resource identifies a resource of issuer.
+type Resource struct {
+	Kind string
+	Name string
+}
+
+type task struct {
+	issuer   string
+	resource Resource
+}
+
+type job struct {
+	task
+	mode Mode
+	// waiters receive the result of forced renewal or rollback
+	waiters []chan Result
+	// serial of the generation to roll back to, empty for the previous one
+	serial string
+}
+
+type result struct {
+	job
+	Result
+}
+
+type schedule struct {
+	next        time.Time
+	mode        Mode
+	running     bool
+	recheck     bool
+	renewBefore time.Duration
+	// force is set by operator, it is applied by the nearest ensure
+	force   bool
+	waiters []chan Result
+	// rollback is requested by operator, it is applied before the forced renewal
+	rollback *pendingRollback
+	// checkInterval overrides the default check interval of scheduler
+	checkInterval time.Duration
+
+	// jitter and renewal attempt are tracked for every certificate generation
+	notAfter   time.Time
+	jitter     time.Duration
+	renewTried bool
+}
+
+type pendingRollback struct {
+	serial string
+	waiter chan Result
+}
+
+// maxJitterShare limits the jitter to the share of the time between the issue and NotAfter - renewBefore,
+// so a short-lived certificate is not renewed right after the issue.
+const maxJitterShare = 4
+
+// scheduler plans ensure of every resource:
+// a certificate is ensured at NotAfter - renewBefore - jitter,
+// besides every resource is checked each checkInterval to catch changes of files on disk.
+type scheduler struct {
+	checkInterval time.Duration
+	jitter        time.Duration
+
+	tasks map[task]*schedule
+}
+
+func newScheduler(checkInterval, jitter time.Duration) *scheduler {
+	return &scheduler{
+		checkInterval: checkInterval,
+		jitter:        jitter,
+		tasks:         make(map[task]*schedule),
+	}
+}
+
+// add plans the immediate ensure of new or changed resource.
+func (s *scheduler) add(t task, renewBefore, checkInterval time.Duration) {
+	sch, isExist := s.tasks[t]
+	if !isExist {
+		sch = &schedule{}
+		s.tasks[t] = sch
+	}
+	sch.next = time.Now()
+	sch.mode = Check
+	sch.recheck = sch.running
+	sch.renewBefore = renewBefore
+	sch.checkInterval = checkInterval
+}
+
+func (s *scheduler) remove(t task) {
+	if sch, isExist := s.tasks[t]; isExist {
+		sch.release(t.resource)
+	}
+	delete(s.tasks, t)
+}
+
+func (s *scheduler) removeIssuer(issuer string) {
+	for t, sch := range s.tasks {
+		if t.issuer == issuer {
+			sch.release(t.resource)
+			delete(s.tasks, t)
+		}
+	}
+}
+
+// force plans the immediate renewal of the issuer certificate, all certificates of the issuer if name is empty
+// or all certificates if issuer is empty too. It returns channels which receive the renewal results.
+func (s *scheduler) force(issuer, name string, now time.Time) map[task]chan Result {
+	r := make(map[task]chan Result)
+	for t, sch := range s.tasks {
+		if t.resource.Kind != KindCertificate ||
+			(issuer != "" && t.issuer != issuer) ||
+			(name != "" && t.resource.Name != name) {
+			continue
+		}
+
+		w := make(chan Result, 1)
+		sch.waiters = append(sch.waiters, w)
+		sch.force = true
+		if !sch.running {
+			// running ensure replans it on done
+			sch.next = now
+		}
+		r[t] = w
+	}
+	return r
+}
+
+// rollback plans the immediate rollback of the certificate to the generation with the serial,
+// to the previous generation if serial is empty. It returns channel which receives the result.
+func (s *scheduler) rollback(t task, serial string, now time.Time) (chan Result, error) {
+	sch, isExist := s.tasks[t]
+	if !isExist {
+		return nil, errCertificateIsNotExist
+	}
+	if sch.rollback != nil {
+		return nil, errRollbackIsPending
+	}
+
+	w := make(chan Result, 1)
+	sch.rollback = &pendingRollback{serial: serial, waiter: w}
+	if !sch.running {
+		sch.next = now
+	}
+	return w, nil
+}
+
+// due returns jobs which time has come and marks them running.
+func (s *scheduler) due(now time.Time) []job {
+	var r []job
+	for t, sch := range s.tasks {
+		if !sch.running && !sch.next.After(now) {
+			sch.running = true
+			j := job{task: t, mode: sch.mode}
+			switch {
+			case sch.rollback != nil:
+				j.mode, j.serial, j.waiters = Rollback, sch.rollback.serial, []chan Result{sch.rollback.waiter}
+				sch.rollback = nil
+			case sch.force:
+				j.mode, j.waiters = Force, sch.waiters
+				sch.force, sch.waiters = false, nil
+			}
+			r = append(r, j)
+		}
+	}
+	return r
+}
+
+// done plans the next ensure of resource by the ensure result
+// and returns the planned certificate renewal and the next ensure time.
+func (s *scheduler) done(r result) (renewAt, next time.Time) {
+	for _, w := range r.waiters {
+		w <- r.Result
+	}
+
+	sch, isExist := s.tasks[r.task]
+	if !isExist {
+		// resource was removed while it was ensured
+		return
+	}
+	sch.running = false
+	defer func() { next = sch.next }()
+
+	checkInterval := s.checkInterval
+	if sch.checkInterval > 0 {
+		checkInterval = sch.checkInterval
+	}
+
+	now := time.Now()
+	sch.next = now.Add(checkInterval)
+	sch.mode = Check
+	if sch.recheck || sch.force || sch.rollback != nil || r.mode == Rollback {
+		// resource was changed or operator request came while it was ensured,
+		// the rolled back certificate is checked at once
+		sch.next = now
+		sch.recheck = false
+	}
+	if r.NotAfter.IsZero() {
+		return
+	}
+
+	if !sch.notAfter.Equal(r.NotAfter) {
+		sch.notAfter = r.NotAfter
+		sch.jitter = s.randomJitter(r.NotBefore, r.NotAfter, sch.renewBefore)
+		sch.renewTried = false
+	}
+	if r.mode == Renew {
+		// issuer may refuse the renewal (e.g. withUpdate is off), do not insist on it
+		sch.renewTried = true
+	}
+	if r.Err != nil {
+		return
+	}
+
+	renewAt = r.NotAfter.Add(-sch.renewBefore - sch.jitter)
+	metrics.SetRenewal(r.issuer, r.resource.Name, renewAt)
+
+	if !sch.renewTried && renewAt.Before(sch.next) && sch.next.After(now) {
+		sch.next = renewAt
+		sch.mode = Renew
+	}
+
+	zap.L().Debug(
+		"schedule",
+		zap.String("issuer_name", r.issuer),
+		zap.String("resource_type", r.resource.Kind),
+		zap.String("name", r.resource.Name),
+		zap.Time("not_after", r.NotAfter),
+		zap.Time("renew_at", renewAt),
+		zap.Time("next_check", sch.next),
+	)
+	return
+}
+
+// release fails the pending forced renewal and rollback of the removed resource.
+func (s *schedule) release(r Resource) {
+	for _, w := range s.waiters {
+		w <- Result{Resource: r, Action: ActionFailed, Err: errResourceIsRemoved}
+	}
+	s.waiters = nil
+	if s.rollback != nil {
+		s.rollback.waiter <- Result{Resource: r, Action: ActionFailed, Err: errResourceIsRemoved}
+		s.rollback = nil
+	}
+}
+
+// next returns time of the nearest ensure, zero time if nothing is planned.
+func (s *scheduler) next() time.Time {
+	var n time.Time
+	for _, sch := range s.tasks {
+		if !sch.running && (n.IsZero() || sch.next.Before(n)) {
+			n = sch.next
+		}
+	}
+	return n
+}
+
+// randomJitter returns offset in [0, jitter) which moves renewal earlier,
+// so a fleet of hosts with certificates issued at the same time does not renew them at once.
+// The jitter is limited by maxJitterShare of the certificate lifetime before renewBefore.
+func (s *scheduler) randomJitter(notBefore, notAfter time.Time, renewBefore time.Duration) time.Duration {
+	jitter := s.jitter
+	if !notBefore.IsZero() {
+		if limit := (notAfter.Sub(notBefore) - renewBefore) / maxJitterShare; limit < jitter {
+			jitter = limit
+		}
+	}
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
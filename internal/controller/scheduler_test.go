@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTask = task{issuer: "vault", resource: Resource{Kind: KindCertificate, Name: "a"}}
+
+func TestSchedulerDue(t *testing.T) {
+	now := time.Now()
+	waiter := make(chan Result, 1)
+
+	tests := []struct {
+		name       string
+		schedule   schedule
+		wantJobs   []job
+		wantForce  bool
+		wantQueued bool
+	}{
+		{
+			name:     "not due",
+			schedule: schedule{next: now.Add(time.Second)},
+		},
+		{
+			name:     "due",
+			schedule: schedule{next: now, mode: Renew},
+			wantJobs: []job{{task: testTask, mode: Renew}},
+		},
+		{
+			name:     "running",
+			schedule: schedule{next: now.Add(-time.Second), running: true},
+		},
+		{
+			name:     "forced",
+			schedule: schedule{next: now, force: true, waiters: []chan Result{waiter}},
+			wantJobs: []job{{task: testTask, mode: Force, waiters: []chan Result{waiter}}},
+		},
+		{
+			name: "rollback goes before forced renewal",
+			schedule: schedule{
+				next:     now,
+				force:    true,
+				waiters:  []chan Result{waiter},
+				rollback: &pendingRollback{serial: "7c:1a", waiter: waiter},
+			},
+			wantJobs:   []job{{task: testTask, mode: Rollback, serial: "7c:1a", waiters: []chan Result{waiter}}},
+			wantForce:  true,
+			wantQueued: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(time.Minute, 0)
+			sch := tt.schedule
+			s.tasks[testTask] = &sch
+
+			assert.Equal(t, tt.wantJobs, s.due(now))
+			assert.Equal(t, len(tt.wantJobs) != 0 || tt.schedule.running, sch.running)
+			assert.Equal(t, tt.wantForce, sch.force)
+			assert.Equal(t, tt.wantQueued, len(sch.waiters) != 0)
+			assert.Nil(t, sch.rollback)
+			// a running task is not due again
+			assert.Empty(t, s.due(now))
+		})
+	}
+}
+
+func TestSchedulerDone(t *testing.T) {
+	const renewBefore = 10 * time.Minute
+	errCheck := errors.New("check")
+
+	tests := []struct {
+		name     string
+		schedule schedule
+		mode     Mode
+		// notAfter is relative to now, zero for a resource without expiry
+		notAfter       time.Duration
+		sameGeneration bool
+		err            error
+
+		wantMode Mode
+		wantNext time.Duration
+		// wantRenewAt is relative to now, zero if renewal is not planned
+		wantRenewAt time.Duration
+	}{
+		{
+			name:     "secret",
+			wantMode: Check,
+			wantNext: time.Minute,
+		},
+		{
+			name:        "check before renewal",
+			notAfter:    time.Hour,
+			wantMode:    Check,
+			wantNext:    time.Minute,
+			wantRenewAt: 50 * time.Minute,
+		},
+		{
+			name:        "renewal before next check",
+			notAfter:    renewBefore + 30*time.Second,
+			wantMode:    Renew,
+			wantNext:    30 * time.Second,
+			wantRenewAt: 30 * time.Second,
+		},
+		{
+			name:        "check interval of resource",
+			schedule:    schedule{checkInterval: 5 * time.Minute},
+			notAfter:    time.Hour,
+			wantMode:    Check,
+			wantNext:    5 * time.Minute,
+			wantRenewAt: 50 * time.Minute,
+		},
+		{
+			name:           "refused renewal is not repeated",
+			mode:           Renew,
+			notAfter:       renewBefore + 30*time.Second,
+			sameGeneration: true,
+			wantMode:       Check,
+			wantNext:       time.Minute,
+			wantRenewAt:    30 * time.Second,
+		},
+		{
+			name:     "failed",
+			notAfter: time.Hour,
+			err:      errCheck,
+			wantMode: Check,
+			wantNext: time.Minute,
+		},
+		{
+			name:        "changed while running",
+			schedule:    schedule{recheck: true},
+			notAfter:    time.Hour,
+			wantMode:    Check,
+			wantRenewAt: 50 * time.Minute,
+		},
+		{
+			name:        "forced while running",
+			schedule:    schedule{force: true},
+			notAfter:    time.Hour,
+			wantMode:    Check,
+			wantRenewAt: 50 * time.Minute,
+		},
+		{
+			name:        "rolled back",
+			mode:        Rollback,
+			notAfter:    time.Hour,
+			wantMode:    Check,
+			wantRenewAt: 50 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			s := newScheduler(time.Minute, 0)
+			sch := tt.schedule
+			sch.running, sch.renewBefore = true, renewBefore
+			s.tasks[testTask] = &sch
+
+			waiter := make(chan Result, 1)
+			r := result{
+				job:    job{task: testTask, mode: tt.mode, waiters: []chan Result{waiter}},
+				Result: Result{Resource: testTask.resource, Err: tt.err},
+			}
+			if tt.notAfter != 0 {
+				r.NotBefore, r.NotAfter = now.Add(-time.Hour), now.Add(tt.notAfter)
+			}
+			if tt.sameGeneration {
+				sch.notAfter = r.NotAfter
+			}
+
+			renewAt, next := s.done(r)
+
+			require.Len(t, waiter, 1)
+			assert.Equal(t, r.Result, <-waiter)
+			assert.False(t, sch.running)
+			assert.False(t, sch.recheck)
+			assert.Equal(t, tt.wantMode, sch.mode)
+			assert.WithinDuration(t, now.Add(tt.wantNext), next, time.Second)
+			if tt.wantRenewAt == 0 {
+				assert.True(t, renewAt.IsZero(), "renewAt %s", renewAt)
+			} else {
+				assert.WithinDuration(t, now.Add(tt.wantRenewAt), renewAt, time.Second)
+			}
+		})
+	}
+}
+
+func TestSchedulerDoneRemoved(t *testing.T) {
+	s := newScheduler(time.Minute, 0)
+	renewAt, next := s.done(result{job: job{task: testTask}, Result: Result{NotAfter: time.Now().Add(time.Hour)}})
+	assert.True(t, renewAt.IsZero())
+	assert.True(t, next.IsZero())
+}
+
+func TestRandomJitter(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		jitter      time.Duration
+		notBefore   time.Time
+		lifetime    time.Duration
+		renewBefore time.Duration
+		wantMax     time.Duration
+	}{
+		{
+			name:        "disabled",
+			notBefore:   now,
+			lifetime:    time.Hour,
+			renewBefore: 10 * time.Minute,
+		},
+		{
+			name:        "long lifetime",
+			jitter:      10 * time.Minute,
+			notBefore:   now,
+			lifetime:    24 * time.Hour,
+			renewBefore: time.Hour,
+			wantMax:     10 * time.Minute,
+		},
+		{
+			name:        "short lifetime",
+			jitter:      10 * time.Minute,
+			notBefore:   now,
+			lifetime:    10 * time.Minute,
+			renewBefore: 5 * time.Minute,
+			wantMax:     5 * time.Minute / maxJitterShare,
+		},
+		{
+			name:        "renewBefore exceeds lifetime",
+			jitter:      10 * time.Minute,
+			notBefore:   now,
+			lifetime:    10 * time.Minute,
+			renewBefore: time.Hour,
+		},
+		{
+			name:        "unknown NotBefore",
+			jitter:      10 * time.Minute,
+			lifetime:    10 * time.Minute,
+			renewBefore: 5 * time.Minute,
+			wantMax:     10 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(time.Minute, tt.jitter)
+			for i := 0; i < 100; i++ {
+				j := s.randomJitter(tt.notBefore, now.Add(tt.lifetime), tt.renewBefore)
+				if tt.wantMax == 0 {
+					require.Zero(t, j)
+					continue
+				}
+				require.GreaterOrEqual(t, j, time.Duration(0))
+				require.Less(t, j, tt.wantMax)
+			}
+		})
+	}
+}
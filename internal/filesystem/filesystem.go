@@ -0,0 +1,111 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+)
+
+// WriteFile writes data to a temporary file in the same dir, syncs it and renames it to filepath,
+// so readers see either the old or the new content, never a truncated file.
+func WriteFile(filepath string, data []byte, perm os.FileMode) error {
+	return WriteFileAs(filepath, data, perm, -1, -1)
+}
+
+// WriteFileAs is WriteFile which sets owner of the file before it becomes visible,
+// negative uid or gid are not changed.
+func WriteFileAs(filepath string, data []byte, perm os.FileMode, uid, gid int) (err error) {
+	dir := path.Dir(filepath)
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "."+path.Base(filepath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if uid >= 0 || gid >= 0 {
+		if err = f.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), filepath); err != nil {
+		return err
+	}
+	return SyncDir(dir)
+}
+
+// Symlink atomically creates or replaces the link with a symbolic link to target.
+func Symlink(target, link string) error {
+	tmp := fmt.Sprintf("%s.tmp-%d", link, os.Getpid())
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return SyncDir(path.Dir(link))
+}
+
+// SyncDir flushes the directory entries, so renames in the dir survive a crash.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Apply sets owner and mode of the file and reports whether anything was changed.
+// Negative uid or gid and zero mode are not changed. Links are followed.
+func Apply(filepath string, uid, gid int, mode os.FileMode) (bool, error) {
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return false, err
+	}
+
+	var isChanged bool
+	if mode != 0 && info.Mode().Perm() != mode.Perm() {
+		if err = os.Chmod(filepath, mode.Perm()); err != nil {
+			return false, err
+		}
+		isChanged = true
+	}
+
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return isChanged, nil
+	}
+	if (uid >= 0 && int(st.Uid) != uid) || (gid >= 0 && int(st.Gid) != gid) {
+		if err = os.Chown(filepath, uid, gid); err != nil {
+			return isChanged, err
+		}
+		isChanged = true
+	}
+	return isChanged, nil
+}
@@ -9,37 +9,50 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/controller"
 )
 
-func (s *vault) ensureCA(cert config.Certificate) {
+func (s *vault) ensureCA(cert config.Certificate, perm permissions, mode controller.Mode) (controller.Action, time.Time, error) {
 	logger := zap.L().With(zap.String("resource_type", "intermediate_ca"), zap.String("name", cert.Name))
 
-	var (
-		crt, key []byte
-		err      error
-	)
+	action := controller.ActionRenewed
+	crt, key, err := s.checkCA(cert, logger)
+	if err == nil && mode == controller.Force && cert.CA.Generate {
+		err = errForcedRenewal
+	}
+	if err != nil {
+		logger.Warn("check", zap.Error(err))
 
-	defer func() {
-		if err := storeKeyPair(cert.HostPath, cert.Name, crt, key); err != nil {
-			logger.Error("store", zap.Error(err))
+		if cert.CA.Generate {
+			if crt, key, err = s.generateCA(cert); err != nil {
+				return controller.ActionFailed, time.Time{}, fmt.Errorf("generate: %w", err)
+			}
+			action = controller.ActionIssued
+			logger.Info("generated")
 		}
-		logger.Debug("store")
-	}()
+	}
 
-	crt, key, err = s.checkCA(cert, logger)
-	if err == nil {
-		return
+	isStored, storeErr := storeKeyPair(cert, crt, key, perm)
+	if storeErr != nil {
+		return controller.ActionFailed, time.Time{}, fmt.Errorf("store: %w", storeErr)
+	}
+	if !isStored && action != controller.ActionIssued {
+		action = controller.ActionNone
 	}
-	logger.Warn("check", zap.Error(err))
+	logger.Debug("store")
 
-	if cert.CA.Generate {
-		crt, key, err = s.generateCA(cert)
-		if err != nil {
-			logger.Error("generate", zap.Error(err))
-			return
-		}
-		zap.L().Info("generated")
+	if crt == nil {
+		return controller.ActionFailed, time.Time{}, err
+	}
+	ca, parseErr := parseCertificate(crt)
+	if parseErr != nil {
+		return controller.ActionFailed, time.Time{}, fmt.Errorf("parse: %w", parseErr)
 	}
+
+	s.mu.Lock()
+	s.checked[cert.Name] = checkedFile{notBefore: ca.NotBefore, notAfter: ca.NotAfter, serial: serialNumber(ca.SerialNumber.Bytes())}
+	s.mu.Unlock()
+	return action, ca.NotAfter, err
 }
 
 func (s *vault) checkCA(cert config.Certificate, l *zap.Logger) ([]byte, []byte, error) {
@@ -78,6 +91,12 @@ func (s *vault) generateCA(cert config.Certificate) (crt, key []byte, err error)
 		"common_name": fmt.Sprintf("%s Intermediate Authority", cert.Name),
 		"ttl":         cert.Spec.TTL,
 	}
+	if cert.Spec.PrivateKey.Algorithm != "" {
+		csrData["key_type"], csrData["key_bits"] = vaultKeyParams(cert.Spec.PrivateKey)
+	}
+	if keyEncoding(cert.Spec.PrivateKey) == keyEncodingPKCS8 {
+		csrData["private_key_format"] = "pkcs8"
+	}
 
 	keyType := "internal"
 	if cert.CA.ExportedKey {
@@ -1,83 +1,171 @@
 package vault
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
-	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/controller"
+	"github.com/fraima/key-keeper/internal/metrics"
 )
 
-func (s *vault) ensureCertificate(cert config.Certificate) {
+func (s *vault) ensureCertificate(cert config.Certificate, perm permissions, mode controller.Mode, reissue bool) (controller.Action, time.Time, error) {
 	logger := zap.L().With(zap.String("resource_type", "certificate"), zap.String("name", cert.Name))
 
-	err := checkCertificate(cert, logger)
-	if err == nil {
-		return
+	notAfter, err := s.checkCertificate(cert)
+
+	var drift *driftError
+	if errors.As(err, &drift) && !cert.WithUpdate && mode != controller.Force {
+		logger.Warn("drift", zap.Strings("fields", drift.fields), zap.Bool("with_update", cert.WithUpdate))
+		s.ignoreDrift(cert.Name)
+		return controller.ActionNone, notAfter, nil
 	}
-	zap.L().Warn("ensure", zap.Error(err))
 
-	if os.IsNotExist(err) || cert.WithUpdate {
-		crt, key, err := s.generateCertificate(cert.Spec)
-		if err != nil {
-			zap.L().Error("generate", zap.Error(err))
-		}
+	switch {
+	case reissue:
+		err = errSpecIsChanged
+	case err == nil && mode == controller.Renew && !cert.WithUpdate:
+		// the valid certificate is reissued only when it expires within renewBefore
+		return controller.ActionNone, notAfter, nil
+	case err == nil && mode == controller.Renew:
+		err = errRenewalTime
+	case err == nil && mode == controller.Force:
+		err = errForcedRenewal
+	case err == nil:
+		return controller.ActionNone, notAfter, nil
+	}
 
-		err = storeKeyPair(cert.HostPath, cert.Name, crt, key)
-		if err != nil {
-			zap.L().Error("store", zap.Error(err))
-			return
-		}
+	action := controller.ActionRenewed
+	switch {
+	case os.IsNotExist(err) || reissue:
+		action = controller.ActionIssued
+	case isVerifyError(err):
+		// the files are unusable, they are replaced regardless of withUpdate
+		action = controller.ActionIssued
+	case !cert.WithUpdate && mode != controller.Force:
+		return controller.ActionFailed, notAfter, err
+	}
+	logger.Warn("ensure", zap.Error(err))
 
-		trigger(cert.Trigger, logger)
-		zap.L().Debug("generated")
+	passwords, err := s.readPasswords(cert)
+	if err != nil {
+		return controller.ActionFailed, notAfter, fmt.Errorf("read passwords: %w", err)
+	}
+	issued, err := s.generateCertificate(cert.Spec)
+	if err != nil {
+		return controller.ActionFailed, notAfter, fmt.Errorf("generate: %w", err)
+	}
+	if err = s.buildOutputs(cert, &issued, passwords); err != nil {
+		return controller.ActionFailed, notAfter, fmt.Errorf("build outputs: %w", err)
 	}
+
+	if _, err = storeFiles(cert, certificateFiles(cert, issued), perm); err != nil {
+		return controller.ActionFailed, notAfter, fmt.Errorf("store: %w", err)
+	}
+	// the new files hold the current CA, so the previous check state is not compared with it
+	s.mu.Lock()
+	delete(s.checked, cert.Name)
+	s.mu.Unlock()
+
+	s.trigger(cert, logger)
+	logger.Debug("generated")
+
+	notAfter, err = s.checkCertificate(cert)
+	if errors.As(err, &drift) {
+		logger.Warn("issued_drift", zap.Strings("fields", drift.fields))
+		s.ignoreDrift(cert.Name)
+		err = nil
+	}
+	if err != nil {
+		return controller.ActionFailed, notAfter, fmt.Errorf("check issued: %w", err)
+	}
+	return action, notAfter, nil
 }
 
-func (s *vault) generateCertificate(certSpec config.Spec) ([]byte, []byte, error) {
-	csr, key, err := s.createCSR(certSpec)
+func (s *vault) generateCertificate(certSpec config.Spec) (issuedCertificate, error) {
+	u, err := parseUsages(certSpec.Usage)
+	if err != nil {
+		return issuedCertificate{}, err
+	}
+
+	template, err := csrTemplate(certSpec, u)
 	if err != nil {
-		return nil, nil, fmt.Errorf("create csr: %w", err)
+		return issuedCertificate{}, err
+	}
+
+	csr, key, err := s.createCSR(certSpec, template)
+	if err != nil {
+		return issuedCertificate{}, fmt.Errorf("create csr: %w", err)
 	}
 
 	certData := map[string]interface{}{
 		"csr": string(csr),
 		"ttl": certSpec.TTL,
 	}
+	if len(template.URIs) != 0 {
+		uris := make([]string, 0, len(template.URIs))
+		for _, u := range template.URIs {
+			uris = append(uris, u.String())
+		}
+		certData["uri_sans"] = strings.Join(uris, ",")
+	}
+	if len(template.EmailAddresses) != 0 {
+		// Vault takes email SANs from alt_names together with DNS names
+		certData["alt_names"] = strings.Join(append(append([]string(nil), template.DNSNames...), template.EmailAddresses...), ",")
+	}
+	keyUsage, extKeyUsage := u.vaultParams()
+	if len(keyUsage) != 0 {
+		certData["key_usage"] = keyUsage
+	}
+	if len(extKeyUsage) != 0 {
+		certData["ext_key_usage"] = extKeyUsage
+	}
 
 	vaultPath := path.Join(s.caPath, "sign", s.role)
 	cert, err := s.cli.Write(vaultPath, certData)
 	if err != nil {
-		return nil, nil, fmt.Errorf("generate with vault path %s : %w", vaultPath, err)
+		return issuedCertificate{}, fmt.Errorf("generate with vault path %s : %w", vaultPath, err)
 	}
 
-	if crt, ok := cert["certificate"]; ok {
-		return []byte(crt.(string)), key, nil
+	crt, ok := cert["certificate"]
+	if !ok {
+		return issuedCertificate{}, fmt.Errorf("certificate block not found")
 	}
-
-	return nil, nil, fmt.Errorf("certificate block not found")
-}
-
-func (s *vault) createCSR(spec config.Spec) (crt, key []byte, err error) {
-	pk, err := rsa.GenerateKey(rand.Reader, spec.PrivateKey.Size)
+	issued, err := parseCertificate([]byte(crt.(string)))
 	if err != nil {
-		err = fmt.Errorf("generate key: %w", err)
-		return
+		return issuedCertificate{}, fmt.Errorf("parse issued: %w", err)
+	}
+	if err = u.verify(issued); err != nil {
+		return issuedCertificate{}, err
+	}
+
+	r := issuedCertificate{crt: []byte(crt.(string)), key: key}
+	if r.ca, r.chain, r.caChain, err = issuerChain(cert); err != nil {
+		return issuedCertificate{}, fmt.Errorf("parse issuer chain: %w", err)
 	}
+	return r, nil
+}
 
+// csrTemplate returns the certificate request built from the spec.
+func csrTemplate(spec config.Spec, u usages) (template x509.CertificateRequest, err error) {
 	commonName, err := getCommonName(spec.Subject.CommonName)
 	if err != nil {
 		err = fmt.Errorf("get common name: %w", err)
@@ -96,7 +184,24 @@ func (s *vault) createCSR(spec config.Spec) (crt, key []byte, err error) {
 		return
 	}
 
-	template := x509.CertificateRequest{
+	uris, err := getURIs(spec)
+	if err != nil {
+		err = fmt.Errorf("get uris: %w", err)
+		return
+	}
+
+	emails, err := getEmails(spec.Emails)
+	if err != nil {
+		err = fmt.Errorf("get emails: %w", err)
+		return
+	}
+
+	extensions, err := u.extensions()
+	if err != nil {
+		return
+	}
+
+	template = x509.CertificateRequest{
 		Subject: pkix.Name{
 			CommonName:         commonName,
 			Country:            spec.Subject.Country,
@@ -108,10 +213,22 @@ func (s *vault) createCSR(spec config.Spec) (crt, key []byte, err error) {
 			StreetAddress:      spec.Subject.StreetAddress,
 			SerialNumber:       spec.Subject.SerialNumber,
 		},
-		IPAddresses:        ips,
-		DNSNames:           dnsNames,
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		IPAddresses:     ips,
+		DNSNames:        dnsNames,
+		URIs:            uris,
+		EmailAddresses:  emails,
+		ExtraExtensions: extensions,
+	}
+	return
+}
+
+func (s *vault) createCSR(spec config.Spec, template x509.CertificateRequest) (crt, key []byte, err error) {
+	pk, signatureAlgorithm, err := generateKey(spec.PrivateKey)
+	if err != nil {
+		err = fmt.Errorf("generate key: %w", err)
+		return
 	}
+	template.SignatureAlgorithm = signatureAlgorithm
 
 	csr, err := x509.CreateCertificateRequest(rand.Reader, &template, pk)
 	if err != nil {
@@ -125,12 +242,9 @@ func (s *vault) createCSR(spec config.Spec) (crt, key []byte, err error) {
 			Bytes: csr,
 		},
 	)
-	key = pem.EncodeToMemory(
-		&pem.Block{
-			Type:  "RSA PRIVATE KEY",
-			Bytes: x509.MarshalPKCS1PrivateKey(pk),
-		},
-	)
+	if key, err = encodeKey(pk, keyEncoding(spec.PrivateKey)); err != nil {
+		err = fmt.Errorf("encode key: %w", err)
+	}
 	return
 }
 
@@ -139,14 +253,31 @@ func getCommonName(src string) (string, error) {
 	return strings.ReplaceAll(src, "$HOSTNAME", hostname), err
 }
 
+// Address families of ip addresses.
+const (
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+	ipFamilyBoth = "both"
+)
+
+// getIPAddresses returns ip addresses of all sources filtered by their families,
+// by default static addresses of both families and IPv4 addresses of interfaces and dns lookup are taken.
+// Link-local and other scoped addresses are skipped unless includeScoped is set.
 func getIPAddresses(cfg config.IPAddresses) ([]net.IP, error) {
 	ipAddresses := make(map[string]net.IP)
+	add := func(ip net.IP, family string) {
+		if ip == nil || !matchIPFamily(ip, family) || (!cfg.IncludeScoped && isScopedIP(ip)) {
+			return
+		}
+		ipAddresses[ip.String()] = ip
+	}
 
 	for _, ip := range cfg.Static {
 		netIP := net.ParseIP(ip)
-		if netIP.To4() != nil {
-			ipAddresses[ip] = netIP
+		if netIP == nil {
+			return nil, fmt.Errorf("parse static ip %s", ip)
 		}
+		add(netIP, ipFamilyOrDefault(cfg.Family.Static, ipFamilyBoth))
 	}
 
 	ifaces, err := net.Interfaces()
@@ -169,10 +300,7 @@ func getIPAddresses(cfg config.IPAddresses) ([]net.IP, error) {
 				case *net.IPAddr:
 					ip = v.IP
 				}
-
-				if ip.To4() != nil {
-					ipAddresses[ip.String()] = ip
-				}
+				add(ip, ipFamilyOrDefault(cfg.Family.Interfaces, ipFamilyIPv4))
 			}
 		}
 	}
@@ -183,9 +311,7 @@ func getIPAddresses(cfg config.IPAddresses) ([]net.IP, error) {
 			return nil, fmt.Errorf("lookup ip for %s ", h)
 		}
 		for _, ip := range ips {
-			if ip.To4() != nil {
-				ipAddresses[ip.String()] = ip
-			}
+			add(ip, ipFamilyOrDefault(cfg.Family.DNSLookup, ipFamilyIPv4))
 		}
 	}
 
@@ -193,18 +319,88 @@ func getIPAddresses(cfg config.IPAddresses) ([]net.IP, error) {
 	for _, ip := range ipAddresses {
 		r = append(r, ip)
 	}
+	sort.Slice(r, func(i, j int) bool {
+		return bytes.Compare(r[i].To16(), r[j].To16()) < 0
+	})
 	return r, nil
 }
 
+func ipFamilyOrDefault(family, def string) string {
+	if family == "" {
+		return def
+	}
+	return strings.ToLower(family)
+}
+
+func matchIPFamily(ip net.IP, family string) bool {
+	isIPv4 := ip.To4() != nil
+	switch family {
+	case ipFamilyIPv4:
+		return isIPv4
+	case ipFamilyIPv6:
+		return !isIPv4
+	}
+	return true
+}
+
+// isScopedIP reports whether the address is valid only within a link or an interface.
+func isScopedIP(ip net.IP) bool {
+	return ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
 func getDNSNames(src []string) ([]string, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, err
 	}
-	for i := range src {
-		src[i] = strings.ReplaceAll(src[i], "$HOSTNAME", hostname)
+	// src belongs to the config and must not be changed
+	r := make([]string, 0, len(src))
+	for _, s := range src {
+		r = append(r, strings.ReplaceAll(s, "$HOSTNAME", hostname))
+	}
+	return r, nil
+}
+
+// getURIs returns URI SANs with the SPIFFE ID, $HOSTNAME is replaced with the host name.
+func getURIs(spec config.Spec) ([]*url.URL, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	src := append([]string(nil), spec.URIs...)
+	if spec.SpiffeID.TrustDomain != "" {
+		src = append(src, spiffeID(spec.SpiffeID))
+	}
+
+	r := make([]*url.URL, 0, len(src))
+	for _, s := range src {
+		u, err := url.Parse(strings.ReplaceAll(s, "$HOSTNAME", hostname))
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" {
+			return nil, fmt.Errorf("uri %s has no scheme", s)
+		}
+		r = append(r, u)
+	}
+	return r, nil
+}
+
+func spiffeID(id config.SpiffeID) string {
+	return "spiffe://" + id.TrustDomain + "/" + strings.TrimPrefix(id.Path, "/")
+}
+
+func getEmails(src []string) ([]string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	r := make([]string, 0, len(src))
+	for _, s := range src {
+		r = append(r, strings.ReplaceAll(s, "$HOSTNAME", hostname))
 	}
-	return src, nil
+	return r, nil
 }
 
 func inSlice(str string, sl []string) bool {
@@ -216,24 +412,111 @@ func inSlice(str string, sl []string) bool {
 	return false
 }
 
-func checkCertificate(cert config.Certificate, l *zap.Logger) error {
-	crt, err := readCertificate(cert.HostPath, cert.Name)
-	if crt != nil {
-		if time.Until(crt.NotAfter) <= cert.RenewBefore {
-			err = fmt.Errorf("expired until(h) %f", time.Until(crt.NotAfter).Hours())
+// checkCertificate returns NotAfter of the certificate on disk and error if it expires within renewBefore,
+// does not match the key or the issuer CA or differs from the spec.
+// The certificate is parsed again only if the file or the issuing CA was changed since the previous check,
+// chain files and keystores of the certificate are reissued on the CA rotation as they hold the previous CA.
+func (s *vault) checkCertificate(cert config.Certificate) (time.Time, error) {
+	info, err := os.Stat(path.Join(cert.HostPath, certificateFile(cert)))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.mu.RLock()
+	checked, isExist := s.checked[cert.Name]
+	s.mu.RUnlock()
+
+	keyInfo, err := os.Stat(path.Join(cert.HostPath, keyFile(cert)))
+	if err != nil {
+		return time.Time{}, &verifyError{reason: "stat key", err: err}
+	}
+	optional := optionalFiles(cert, issuedCertificate{})
+	for _, f := range optional {
+		if _, err = os.Stat(path.Join(cert.HostPath, f.name)); err != nil {
+			return time.Time{}, &verifyError{reason: "stat " + f.name, err: err}
+		}
+	}
+
+	// the issuing CA is fetched from Vault every caRefreshInterval,
+	// a certificate verified while Vault was unavailable has no CA to compare with
+	_, ca, caErr := s.issuingCA(false)
+	isCAChanged := caErr == nil && checked.caFingerprint != ca
+	isRotated := isExist && isCAChanged && checked.caFingerprint != [sha256.Size]byte{}
+
+	if !isExist || isCAChanged ||
+		!checked.modTime.Equal(info.ModTime()) || checked.size != info.Size() ||
+		!checked.keyModTime.Equal(keyInfo.ModTime()) || checked.keySize != keyInfo.Size() {
+		crt, err := readCertificate(cert.HostPath, cert)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return time.Time{}, err
+			}
+			return time.Time{}, &verifyError{reason: "corrupt certificate", err: err}
+		}
+		if err = s.verifyKeyPair(cert.HostPath, cert, crt); err != nil {
+			return crt.NotAfter, err
+		}
+		if isRotated && len(optional) != 0 {
+			return crt.NotAfter, &verifyError{reason: "issuer ca is rotated"}
+		}
+		// the CA might be fetched again by the chain verification
+		_, ca, _ = s.issuingCA(false)
+
+		checked = checkedFile{
+			modTime:    info.ModTime(),
+			size:       info.Size(),
+			keyModTime: keyInfo.ModTime(),
+			keySize:    keyInfo.Size(),
+			notBefore:  crt.NotBefore,
+			notAfter:   crt.NotAfter,
+			serial:     serialNumber(crt.SerialNumber.Bytes()),
+			crt:        crt,
+
+			caFingerprint: ca,
+		}
+		s.mu.Lock()
+		s.checked[cert.Name] = checked
+		s.mu.Unlock()
+	}
+
+	if time.Until(checked.notAfter) <= cert.RenewBefore {
+		return checked.notAfter, fmt.Errorf("expired until(h) %f", time.Until(checked.notAfter).Hours())
+	}
+
+	if !checked.ignoreDrift {
+		// interface addresses and dns lookup may change at any time, so drift is checked every time
+		fields, err := certificateDrift(checked.crt, cert.Spec)
+		if err != nil {
+			zap.L().Warn("drift", zap.String("name", cert.Name), zap.Error(err))
+		} else if len(fields) != 0 {
+			return checked.notAfter, &driftError{fields: fields}
 		}
 	}
-	return err
+	return checked.notAfter, nil
+}
+
+func (s *vault) ignoreDrift(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if checked, isExist := s.checked[name]; isExist {
+		checked.ignoreDrift = true
+		s.checked[name] = checked
+	}
 }
 
-func trigger(trigger [][]string, logger *zap.Logger) {
-	for _, command := range trigger {
-		var err error
-		if len(command) == 1 {
-			err = exec.Command(command[0]).Run()
-		} else {
-			err = exec.Command(command[0], command[1:]...).Run()
+// triggerTimeout limits the run of a trigger command, a hung command is killed to not block the certificate ensure.
+var triggerTimeout = time.Minute
+
+func (s *vault) trigger(cert config.Certificate, logger *zap.Logger) {
+	for _, command := range cert.Trigger {
+		ctx, cancel := context.WithTimeout(context.Background(), triggerTimeout)
+		err := exec.CommandContext(ctx, command[0], command[1:]...).Run()
+		if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf("killed after %s: %w", triggerTimeout, err)
 		}
+		cancel()
+		metrics.TriggerTotal.WithLabelValues(s.name, cert.Name, metrics.Result(err)).Inc()
 
 		if err != nil {
 			logger.Error("trigger", zap.Strings("command", command), zap.Error(err))
@@ -0,0 +1,117 @@
+package vault
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/controller"
+)
+
+func TestEnsureCertificateWithoutPassword(t *testing.T) {
+	s, cli := newTestVault(t, newTestCA(t))
+	cert := config.Certificate{
+		Name:     "server",
+		HostPath: t.TempDir(),
+		Outputs: []config.Output{{
+			Format:       outputFormatPKCS12,
+			PasswordFrom: config.PasswordFrom{File: filepath.Join(t.TempDir(), "missing")},
+		}},
+	}
+
+	action, _, err := s.ensureCertificate(cert, testPermissions, controller.Check, false)
+	assert.Equal(t, controller.ActionFailed, action)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "read passwords")
+	// nothing is signed in Vault as the keystore can not be built
+	cli.AssertNotCalled(t, "Write", mock.Anything, mock.Anything)
+}
+
+func TestCheckCertificateCARotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		chainFiles config.ChainFiles
+		rotate     func(ca *testCA, t *testing.T)
+		wantReason string
+	}{
+		{
+			name:       "unchanged ca",
+			chainFiles: config.ChainFiles{CA: config.ChainFile{Enabled: true}},
+			rotate:     func(*testCA, *testing.T) {},
+		},
+		{
+			name:   "renewed ca without chain files",
+			rotate: (*testCA).renew,
+		},
+		{
+			name:       "renewed ca with chain files",
+			chainFiles: config.ChainFiles{CA: config.ChainFile{Enabled: true}},
+			rotate:     (*testCA).renew,
+			wantReason: "issuer ca is rotated",
+		},
+		{
+			name:       "ca of a new key",
+			rotate:     (*testCA).rotate,
+			wantReason: "certificate is not issued by issuer CA",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newTestCA(t)
+			s, _ := newTestVault(t, ca)
+			cert := config.Certificate{
+				Name:        "server",
+				HostPath:    t.TempDir(),
+				RenewBefore: time.Minute,
+				ChainFiles:  tt.chainFiles,
+			}
+
+			crt, key := ca.issue(t, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+			issued := issuedCertificate{crt: crt, key: key, ca: ca.pem, chain: []byte{}, caChain: ca.pem}
+			_, err := storeFiles(cert, certificateFiles(cert, issued), testPermissions)
+			require.NoError(t, err)
+
+			_, err = s.checkCertificate(cert)
+			require.False(t, isVerifyError(err), "err %v", err)
+
+			tt.rotate(ca, t)
+			// the cached CA is fetched again on the next check
+			s.caFetchedAt = time.Time{}
+
+			_, err = s.checkCertificate(cert)
+			if tt.wantReason == "" {
+				assert.False(t, isVerifyError(err), "err %v", err)
+				return
+			}
+			var verr *verifyError
+			require.ErrorAs(t, err, &verr)
+			assert.Equal(t, tt.wantReason, verr.reason)
+		})
+	}
+}
+
+func TestTriggerTimeout(t *testing.T) {
+	timeout := triggerTimeout
+	triggerTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { triggerTimeout = timeout })
+
+	s, _ := newTestVault(t, newTestCA(t))
+	done := filepath.Join(t.TempDir(), "done")
+	cert := config.Certificate{
+		Name:    "server",
+		Trigger: [][]string{{"sleep", "10"}, {"touch", done}},
+	}
+
+	start := time.Now()
+	s.trigger(cert, zap.NewNop())
+	// the hung command is killed and the next one is run
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.FileExists(t, done)
+}
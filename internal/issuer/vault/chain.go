@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+// issuedCertificate is the certificate signed by Vault with its key and the issuer chain.
+type issuedCertificate struct {
+	crt, key []byte
+	// ca is the issuing CA, chain holds the intermediate CAs from the issuing one up to the root,
+	// caChain holds all CAs of the issuer including the root if Vault knows it
+	ca, chain, caChain []byte
+	// outputs are the keystores and the files by file name
+	outputs map[string][]byte
+}
+
+// issuerChain returns the issuing CA, the intermediate CAs without self-signed roots and all CAs of the sign response.
+func issuerChain(resp map[string]interface{}) (ca, chain, caChain []byte, err error) {
+	issuingCA, _ := resp["issuing_ca"].(string)
+	ca = pemJoin([]byte(issuingCA))
+
+	var certs []string
+	if caChain, ok := resp["ca_chain"].([]interface{}); ok {
+		for _, c := range caChain {
+			if s, ok := c.(string); ok {
+				certs = append(certs, s)
+			}
+		}
+	} else if issuingCA != "" {
+		certs = append(certs, issuingCA)
+	}
+
+	chain, caChain = []byte{}, []byte{}
+	for _, c := range certs {
+		crt, err := parseCertificate([]byte(c))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		caChain = append(caChain, pemJoin([]byte(c))...)
+		if !bytes.Equal(crt.RawIssuer, crt.RawSubject) {
+			chain = append(chain, pemJoin([]byte(c))...)
+		}
+	}
+	return ca, chain, caChain, nil
+}
+
+// certificateFiles returns all files of the certificate,
+// files of the zero issued certificate have nil data and refer to the current files.
+func certificateFiles(cert config.Certificate, issued issuedCertificate) []storedFile {
+	return append(keyPairFiles(cert, issued.crt, issued.key), optionalFiles(cert, issued)...)
+}
+
+// optionalFiles returns the enabled chain files and the keystores of the certificate.
+func optionalFiles(cert config.Certificate, issued issuedCertificate) []storedFile {
+	return append(chainFiles(cert, issued), outputFiles(cert, issued)...)
+}
+
+// chainFiles returns the enabled chain files of the certificate.
+func chainFiles(cert config.Certificate, issued issuedCertificate) []storedFile {
+	var ca, chain, fullChain, bundle []byte
+	if issued.crt != nil {
+		ca = pemJoin(issued.ca)
+		chain = pemJoin(issued.chain)
+		fullChain = pemJoin(issued.crt, issued.chain)
+		bundle = pemJoin(issued.key, issued.crt, issued.chain)
+	}
+
+	var r []storedFile
+	add := func(f config.ChainFile, file string, data []byte, perm os.FileMode) {
+		if f.Enabled {
+			r = append(r, storedFile{name: chainFileName(cert.Name, f, file), data: data, perm: perm})
+		}
+	}
+	add(cert.ChainFiles.CA, "ca", ca, certificateMode)
+	add(cert.ChainFiles.Chain, "chain", chain, certificateMode)
+	add(cert.ChainFiles.FullChain, "fullchain", fullChain, certificateMode)
+	// bundle holds the key, so it is protected as the key
+	add(cert.ChainFiles.Bundle, "bundle", bundle, keyMode)
+	return r
+}
+
+func chainFileName(name string, f config.ChainFile, file string) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return name + "-" + file + ".pem"
+}
+
+// pemJoin concatenates PEM blocks, every block ends with a newline.
+// The result is never nil, so an empty chain is written as an empty file.
+func pemJoin(blocks ...[]byte) []byte {
+	r := []byte{}
+	for _, b := range blocks {
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+		r = append(append(r, b...), '\n')
+	}
+	return r
+}
+
+// validateFiles checks that the optional files are supported and their names are unique files in hostPath.
+func validateFiles(cert config.Certificate) error {
+	if len(optionalFiles(cert, issuedCertificate{})) != 0 && cert.IsCA {
+		return fmt.Errorf("chainFiles and outputs are not supported for intermediate ca")
+	}
+
+	names := make(map[string]struct{})
+	for _, f := range certificateFiles(cert, issuedCertificate{}) {
+		if strings.HasPrefix(f.name, ".") || strings.Contains(f.name, "/") {
+			return fmt.Errorf("invalid file name %s, files are written to hostPath", f.name)
+		}
+		if _, isExist := names[f.name]; isExist {
+			return fmt.Errorf("duplicate file name %s", f.name)
+		}
+		names[f.name] = struct{}{}
+	}
+	return nil
+}
@@ -12,8 +12,13 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/filesystem"
+	"github.com/fraima/key-keeper/internal/metrics"
 )
 
+// tokenRetryInterval is an interval between attempts of failed token renewal.
+const tokenRetryInterval = 10 * time.Second
+
 func (s *client) auth(name string, a config.Auth) error {
 	token, err := s.getBootstrapToken(a.Bootstrap)
 	if err != nil {
@@ -45,22 +50,60 @@ func (s *client) auth(name string, a config.Auth) error {
 	if err != nil {
 		return fmt.Errorf("get role token: %w", err)
 	}
-	s.cli.SetToken(token)
+	s.setToken(token, ttl)
+
+	if ttl <= 0 {
+		// token does not expire
+		return nil
+	}
 
 	go func() {
 		t := time.NewTimer(ttl / 2)
-		for range t.C {
+		defer t.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+			}
+
 			token, ttl, err := s.getRoleToken(appRoleAuth)
+			metrics.TokenRenewalTotal.WithLabelValues(name, metrics.Result(err)).Inc()
 			if err != nil {
+				// keep the current token while it is alive
 				zap.L().Error("update auth token", zap.String("issuer_name", name), zap.Error(err))
+				t.Reset(tokenRetryInterval)
+				continue
+			}
+			s.setToken(token, ttl)
+			if ttl <= 0 {
+				return
 			}
-			s.cli.SetToken(token)
 			t.Reset(ttl / 2)
 		}
 	}()
 	return nil
 }
 
+func (s *client) setToken(token string, ttl time.Duration) {
+	s.cli.SetToken(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ttl > 0 {
+		s.tokenExpiry = time.Now().Add(ttl)
+	} else {
+		s.tokenExpiry = time.Time{}
+	}
+}
+
+// TokenExpiry returns expiry time of the current token, zero time if the token does not expire.
+func (s *client) TokenExpiry() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokenExpiry
+}
+
 func (s *client) getBootstrapToken(a config.Bootstrap) (string, error) {
 	if a.Token != "" {
 		return a.Token, nil
@@ -89,7 +132,7 @@ func (s *client) getRoleID(name string, appRole config.AppRole) (string, error)
 		return "", fmt.Errorf("not found role_id")
 	}
 
-	if err = writeToFile(appRole.RoleIDLocalPath, []byte(roleID.(string))); err != nil {
+	if err = filesystem.WriteFile(appRole.RoleIDLocalPath, []byte(roleID.(string)), 0644); err != nil {
 		return "", fmt.Errorf("save role id path: %s : %w", appRole.RoleIDLocalPath, err)
 	}
 	return roleID.(string), err
@@ -114,7 +157,7 @@ func (s *client) getSecretID(name string, appRole config.AppRole) (string, error
 		return "", fmt.Errorf("not found secrete_id")
 	}
 
-	if err = writeToFile(appRole.SecretIDLocalPath, []byte(secretID.(string))); err != nil {
+	if err = filesystem.WriteFile(appRole.SecretIDLocalPath, []byte(secretID.(string)), 0644); err != nil {
 		return "", fmt.Errorf("save secret id path: %s : %w", appRole.SecretIDLocalPath, err)
 	}
 	return secretID.(string), err
@@ -4,16 +4,26 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/vault/api"
 
 	"github.com/fraima/key-keeper/internal/config"
 	"github.com/fraima/key-keeper/internal/issuer/vault"
+	"github.com/fraima/key-keeper/internal/metrics"
 )
 
 type client struct {
-	cli *api.Client
+	cli  *api.Client
+	name string
+
+	mu          sync.RWMutex
+	tokenExpiry time.Time
+
+	// stop ends the token renewal
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 // Connect to vault issuer.
@@ -38,7 +48,9 @@ func Connect(name string, cfg config.Vault) (vault.Client, error) {
 	}
 
 	s := &client{
-		cli: cli,
+		cli:  cli,
+		name: name,
+		stop: make(chan struct{}),
 	}
 
 	if err = s.auth(name, cfg.Auth); err != nil {
@@ -47,9 +59,18 @@ func Connect(name string, cfg config.Vault) (vault.Client, error) {
 	return s, err
 }
 
+// Close stops the token renewal, the client must not be used after it.
+func (s *client) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
 // Read secret from vault by path.
 func (s *client) Read(path string) (map[string]interface{}, error) {
+	start := time.Now()
 	sec, err := s.cli.Logical().Read(path)
+	metrics.ObserveVaultRequest(s.name, "read", start, err)
 	if sec != nil {
 		return sec.Data, err
 	}
@@ -58,7 +79,9 @@ func (s *client) Read(path string) (map[string]interface{}, error) {
 
 // Write secret in vault by path.
 func (s *client) Write(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	start := time.Now()
 	sec, err := s.cli.Logical().Write(path, data)
+	metrics.ObserveVaultRequest(s.name, "write", start, err)
 	if sec != nil {
 		return sec.Data, err
 	}
@@ -67,13 +90,17 @@ func (s *client) Write(path string, data map[string]interface{}) (map[string]int
 
 // Put in Vault KV.
 func (s *client) Put(kvMountPath, secretePath string, data map[string]interface{}) error {
+	start := time.Now()
 	_, err := s.cli.KVv2(kvMountPath).Put(context.Background(), secretePath, data)
+	metrics.ObserveVaultRequest(s.name, "put", start, err)
 	return err
 }
 
 // Get from Vault KV.
 func (s *client) Get(kvMountPath, secretePath string) (map[string]interface{}, error) {
+	start := time.Now()
 	sec, err := s.cli.KVv2(kvMountPath).Get(context.Background(), secretePath)
+	metrics.ObserveVaultRequest(s.name, "get", start, err)
 	if sec != nil {
 		return sec.Data, err
 	}
@@ -0,0 +1,124 @@
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+// driftError reports fields of the certificate on disk which differ from the spec.
+type driftError struct {
+	fields []string
+}
+
+func (e *driftError) Error() string {
+	return fmt.Sprintf("certificate differs from spec: %s", strings.Join(e.fields, ", "))
+}
+
+// certificateDrift returns fields of the certificate which differ from the request createCSR would produce now.
+// Subject fields other than the common name are compared only if they are set in the spec,
+// Vault may add the common name to DNS or email SANs.
+func certificateDrift(crt *x509.Certificate, spec config.Spec) ([]string, error) {
+	u, err := parseUsages(spec.Usage)
+	if err != nil {
+		return nil, err
+	}
+	template, err := csrTemplate(spec, u)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	add := func(field string, isDrifted bool) {
+		if isDrifted {
+			fields = append(fields, field)
+		}
+	}
+
+	subject := template.Subject
+	add("subject.commonName", subject.CommonName != crt.Subject.CommonName)
+	add("subject.country", isSetAndDiffer(subject.Country, crt.Subject.Country))
+	add("subject.locality", isSetAndDiffer(subject.Locality, crt.Subject.Locality))
+	add("subject.organization", isSetAndDiffer(subject.Organization, crt.Subject.Organization))
+	add("subject.organizationalUnit", isSetAndDiffer(subject.OrganizationalUnit, crt.Subject.OrganizationalUnit))
+	add("subject.province", isSetAndDiffer(subject.Province, crt.Subject.Province))
+	add("subject.postalCode", isSetAndDiffer(subject.PostalCode, crt.Subject.PostalCode))
+	add("subject.streetAddress", isSetAndDiffer(subject.StreetAddress, crt.Subject.StreetAddress))
+	add("subject.serialNumber", subject.SerialNumber != "" && subject.SerialNumber != crt.Subject.SerialNumber)
+
+	add("hostnames", !equalSet(withCommonName(template.DNSNames, crt), crt.DNSNames))
+	add("ipAddresses", !equalSet(ipStrings(template.IPAddresses), ipStrings(crt.IPAddresses)))
+	add("uris", !equalSet(uriStrings(template.URIs), uriStrings(crt.URIs)))
+	add("emails", !equalSet(withCommonName(template.EmailAddresses, crt), crt.EmailAddresses))
+	add("privateKey", !matchPublicKey(crt, spec.PrivateKey))
+	add("usage", u.verify(crt) != nil)
+	return fields, nil
+}
+
+func isSetAndDiffer(spec, crt []string) bool {
+	return len(spec) != 0 && !equalSet(spec, crt)
+}
+
+// withCommonName adds the common name of the certificate if it is among the certificate SANs.
+func withCommonName(names []string, crt *x509.Certificate) []string {
+	for _, n := range append(append([]string(nil), crt.DNSNames...), crt.EmailAddresses...) {
+		if n == crt.Subject.CommonName && n != "" {
+			return append(append([]string(nil), names...), n)
+		}
+	}
+	return names
+}
+
+func matchPublicKey(crt *x509.Certificate, spec config.PrivateKey) bool {
+	switch k := crt.PublicKey.(type) {
+	case *rsa.PublicKey:
+		size := spec.Size
+		if size == 0 {
+			size = defaultRSAKeySize
+		}
+		return keyAlgorithm(spec) == keyAlgorithmRSA && k.N.BitLen() == size
+	case *ecdsa.PublicKey:
+		return keyAlgorithm(spec) == keyAlgorithmECDSA && curves[spec.Size] == k.Curve
+	case ed25519.PublicKey:
+		return keyAlgorithm(spec) == keyAlgorithmEd25519
+	}
+	return false
+}
+
+func equalSet(a, b []string) bool {
+	set := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		set[s] = struct{}{}
+	}
+	other := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		if _, isExist := set[s]; !isExist {
+			return false
+		}
+		other[s] = struct{}{}
+	}
+	return len(set) == len(other)
+}
+
+func ipStrings(ips []net.IP) []string {
+	r := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		r = append(r, ip.String())
+	}
+	return r
+}
+
+func uriStrings(uris []*url.URL) []string {
+	r := make([]string, 0, len(uris))
+	for _, u := range uris {
+		r = append(r, u.String())
+	}
+	return r
+}
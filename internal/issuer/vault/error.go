@@ -0,0 +1,18 @@
+package vault
+
+import "errors"
+
+var (
+	errResourceIsNotExist  = errors.New("resource is not exist")
+	errSpecIsChanged       = errors.New("spec is changed")
+	errRenewalTime         = errors.New("renewal time has come")
+	errForcedRenewal       = errors.New("renewal is forced")
+	errNameIsEmpty         = errors.New("name is empty")
+	errHostPathIsEmpty     = errors.New("host path is empty")
+	errPEMIsCorrupt        = errors.New("PEM block is not found")
+	errGenerationNotFound  = errors.New("generation is not found")
+	errGenerationIsCurrent = errors.New("generation is current")
+	errGenerationExpiring  = errors.New("generation expires within renewBefore")
+	errCAIsUnavailable     = errors.New("issuing ca is unavailable")
+	errPasswordIsNotUCS2   = errors.New("password has chars out of UCS-2")
+)
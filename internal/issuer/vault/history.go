@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/controller"
+)
+
+// Rollback makes the previous generation of the certificate current, the generation with the serial if it is set,
+// and runs the triggers. The restored certificate is not reissued because of drift from the spec.
+func (s *vault) Rollback(r controller.Resource, serial string) (result controller.Result) {
+	result.Resource = r
+	defer func() {
+		if result.Err != nil {
+			result.Action = controller.ActionFailed
+		}
+		s.mu.RLock()
+		result.Serial, result.NotBefore = s.checked[r.Name].serial, s.checked[r.Name].notBefore
+		s.mu.RUnlock()
+	}()
+
+	s.mu.RLock()
+	cert, isExist := s.certificate[r.Name]
+	s.mu.RUnlock()
+
+	switch {
+	case r.Kind != controller.KindCertificate || !isExist:
+		result.Err = errResourceIsNotExist
+		return
+	case cert.IsCA:
+		result.Err = fmt.Errorf("rollback of intermediate ca is not supported")
+		return
+	}
+	result.Path = path.Join(cert.HostPath, certificateFile(cert))
+	logger := zap.L().With(zap.String("resource_type", "certificate"), zap.String("name", cert.Name))
+
+	perm, err := resolvePermissions(cert.Permissions)
+	if err != nil {
+		result.Err = err
+		return
+	}
+
+	gen, err := s.findGeneration(cert, serial)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	if err = switchGeneration(cert.HostPath, cert.Name, gen, certificateFiles(cert, issuedCertificate{})); err != nil {
+		result.Err = err
+		return
+	}
+	if err = enforceCertificate(cert, perm); err != nil {
+		result.Err = err
+		return
+	}
+	logger.Info("rollback", zap.String("generation", gen))
+	s.trigger(cert, logger)
+
+	result.NotAfter, err = s.checkCertificate(cert)
+	var drift *driftError
+	if errors.As(err, &drift) {
+		logger.Warn("rollback_drift", zap.Strings("fields", drift.fields))
+		s.ignoreDrift(cert.Name)
+		err = nil
+	}
+	if err != nil {
+		result.Err = fmt.Errorf("check rolled back: %w", err)
+		return
+	}
+	result.Action = controller.ActionRolledBack
+	return
+}
+
+// findGeneration returns the generation with the serial or the newest generation created before the current one.
+// The generation must hold a valid key pair which does not expire within renewBefore.
+func (s *vault) findGeneration(cert config.Certificate, serial string) (string, error) {
+	current, err := os.Readlink(path.Join(cert.HostPath, currentLink(cert.Name)))
+	if err != nil {
+		return "", fmt.Errorf("read current generation: %w", err)
+	}
+
+	if serial != "" {
+		gen := generationName(serial)
+		if gen == current {
+			return "", errGenerationIsCurrent
+		}
+		if owner, err := generationOwner(path.Join(cert.HostPath, historyDir, gen)); err != nil || owner != cert.Name {
+			return "", errGenerationNotFound
+		}
+		return gen, s.verifyGeneration(cert, gen)
+	}
+
+	gens, err := generations(cert.HostPath, cert.Name)
+	if err != nil {
+		return "", fmt.Errorf("list generations: %w", err)
+	}
+	var currentCreated time.Time
+	for _, g := range gens {
+		if g.name == current {
+			currentCreated = g.created
+		}
+	}
+	for i := len(gens) - 1; i >= 0; i-- {
+		gen := gens[i]
+		if gen.name == current || (!currentCreated.IsZero() && !gen.created.Before(currentCreated)) {
+			continue
+		}
+		return gen.name, s.verifyGeneration(cert, gen.name)
+	}
+	return "", errGenerationNotFound
+}
+
+func (s *vault) verifyGeneration(cert config.Certificate, gen string) error {
+	dir := path.Join(cert.HostPath, historyDir, gen)
+	crt, err := readCertificate(dir, cert)
+	if err != nil {
+		return fmt.Errorf("read generation %s : %w", gen, err)
+	}
+	if err = s.verifyKeyPair(dir, cert, crt); err != nil {
+		return fmt.Errorf("generation %s : %w", gen, err)
+	}
+	if time.Until(crt.NotAfter) <= cert.RenewBefore {
+		return fmt.Errorf("generation %s : %w, expires at %s", gen, errGenerationExpiring, crt.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package vault
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/controller"
+)
+
+// storeTestGenerations stores the generations of the certificates in order,
+// the generations are created a minute apart as the files are stored within the same mtime tick.
+func storeTestGenerations(t *testing.T, cert config.Certificate, crts, keys [][]byte) []string {
+	t.Helper()
+	var (
+		gens []string
+		now  = time.Now()
+	)
+	for i := range crts {
+		isChanged, err := storeKeyPair(cert, crts[i], keys[i], testPermissions)
+		require.NoError(t, err)
+		require.True(t, isChanged)
+
+		gen := testGeneration(t, crts[i])
+		created := now.Add(time.Duration(i-len(crts)) * time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Join(cert.HostPath, historyDir, gen, ownerFile), created, created))
+		gens = append(gens, gen)
+	}
+	return gens
+}
+
+// testSerial returns the serial of the certificate as it is printed by openssl.
+func testSerial(t *testing.T, crt []byte) string {
+	t.Helper()
+	c, err := parseCertificate(crt)
+	require.NoError(t, err)
+	return strings.ToUpper(serialNumber(c.SerialNumber.Bytes()))
+}
+
+func TestFindGeneration(t *testing.T) {
+	tests := []struct {
+		name string
+		// expires lists generations which expire within renewBefore
+		expires []int
+		// current is the generation switched to before the lookup
+		current int
+		serial  func(t *testing.T, crts [][]byte) string
+		// prepare corrupts the stored generations
+		prepare func(t *testing.T, cert config.Certificate, gens []string)
+		want    int
+		wantErr error
+	}{
+		{
+			name:    "previous generation",
+			current: 2,
+			want:    1,
+		},
+		{
+			name:    "previous of rolled back generation",
+			current: 1,
+			want:    0,
+		},
+		{
+			name:    "no previous generation",
+			current: 0,
+			wantErr: errGenerationNotFound,
+		},
+		{
+			name:    "serial with colons",
+			current: 2,
+			serial:  func(t *testing.T, crts [][]byte) string { return testSerial(t, crts[0]) },
+			want:    0,
+		},
+		{
+			name:    "serial without colons",
+			current: 2,
+			serial:  func(t *testing.T, crts [][]byte) string { return testGeneration(t, crts[0]) },
+			want:    0,
+		},
+		{
+			name:    "serial of newer generation",
+			current: 0,
+			serial:  func(t *testing.T, crts [][]byte) string { return testSerial(t, crts[2]) },
+			want:    2,
+		},
+		{
+			name:    "serial of current generation",
+			current: 2,
+			serial:  func(t *testing.T, crts [][]byte) string { return testSerial(t, crts[2]) },
+			wantErr: errGenerationIsCurrent,
+		},
+		{
+			name:    "unknown serial",
+			current: 2,
+			serial:  func(*testing.T, [][]byte) string { return "ff:ff" },
+			wantErr: errGenerationNotFound,
+		},
+		{
+			name:    "serial of generation of another certificate",
+			current: 2,
+			serial:  func(t *testing.T, crts [][]byte) string { return testSerial(t, crts[0]) },
+			prepare: func(t *testing.T, cert config.Certificate, gens []string) {
+				owner := filepath.Join(cert.HostPath, historyDir, gens[0], ownerFile)
+				require.NoError(t, os.WriteFile(owner, []byte("other"), 0644))
+			},
+			wantErr: errGenerationNotFound,
+		},
+		{
+			name:    "key does not match",
+			current: 2,
+			prepare: func(t *testing.T, cert config.Certificate, gens []string) {
+				_, key := newTestCA(t).issue(t, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+				require.NoError(t, os.WriteFile(filepath.Join(cert.HostPath, historyDir, gens[1], "server-key.pem"), key, 0600))
+			},
+			want:    1,
+			wantErr: &verifyError{reason: "key does not match certificate"},
+		},
+		{
+			name:    "expires within renewBefore",
+			expires: []int{1},
+			current: 2,
+			want:    1,
+			wantErr: errGenerationExpiring,
+		},
+		{
+			name:    "serial of generation expiring within renewBefore",
+			expires: []int{0},
+			current: 2,
+			serial:  func(t *testing.T, crts [][]byte) string { return testSerial(t, crts[0]) },
+			want:    0,
+			wantErr: errGenerationExpiring,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				ca         = newTestCA(t)
+				s, _       = newTestVault(t, ca)
+				cert       = config.Certificate{Name: "server", HostPath: t.TempDir(), RenewBefore: time.Hour, History: config.History{Limit: 5}}
+				crts, keys [][]byte
+			)
+			for i := 0; i < 3; i++ {
+				notAfter := time.Now().Add(24 * time.Hour)
+				for _, e := range tt.expires {
+					if e == i {
+						notAfter = time.Now().Add(30 * time.Minute)
+					}
+				}
+				crt, key := ca.issue(t, time.Now().Add(-time.Minute), notAfter)
+				crts, keys = append(crts, crt), append(keys, key)
+			}
+			gens := storeTestGenerations(t, cert, crts, keys)
+			require.NoError(t, switchGeneration(cert.HostPath, cert.Name, gens[tt.current], certificateFiles(cert, issuedCertificate{})))
+			if tt.prepare != nil {
+				tt.prepare(t, cert, gens)
+			}
+
+			var serial string
+			if tt.serial != nil {
+				serial = tt.serial(t, crts)
+			}
+			gen, err := s.findGeneration(cert, serial)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				require.NoError(t, err)
+				assert.Equal(t, gens[tt.want], gen)
+			case *verifyError:
+				var verr *verifyError
+				require.ErrorAs(t, err, &verr)
+				assert.Equal(t, want.reason, verr.reason)
+				assert.Contains(t, err.Error(), gens[tt.want])
+			default:
+				assert.ErrorIs(t, err, want)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	var (
+		ca   = newTestCA(t)
+		s, _ = newTestVault(t, ca)
+		cert = config.Certificate{Name: "server", HostPath: t.TempDir(), RenewBefore: time.Hour, History: config.History{Limit: 5}}
+		r    = controller.Resource{Kind: controller.KindCertificate, Name: "server"}
+	)
+	var crts, keys [][]byte
+	for i := 0; i < 2; i++ {
+		crt, key := ca.issue(t, time.Now().Add(-time.Minute), time.Now().Add(24*time.Hour))
+		crts, keys = append(crts, crt), append(keys, key)
+	}
+	gens := storeTestGenerations(t, cert, crts, keys)
+
+	result := s.Rollback(r, "")
+	assert.ErrorIs(t, result.Err, errResourceIsNotExist)
+	assert.Equal(t, controller.ActionFailed, result.Action)
+
+	s.certificate[cert.Name] = cert
+	result = s.Rollback(r, "")
+	require.NoError(t, result.Err)
+	assert.Equal(t, controller.ActionRolledBack, result.Action)
+	assert.Equal(t, filepath.Join(cert.HostPath, "server.pem"), result.Path)
+	assert.Equal(t, testSerial(t, crts[0]), strings.ToUpper(result.Serial))
+	assertLinked(t, cert.HostPath, "server", "server.pem", crts[0])
+	assertLinked(t, cert.HostPath, "server", "server-key.pem", keys[0])
+
+	// the rolled back generation is current now
+	result = s.Rollback(r, testSerial(t, crts[0]))
+	assert.ErrorIs(t, result.Err, errGenerationIsCurrent)
+
+	result = s.Rollback(r, gens[1])
+	require.NoError(t, result.Err)
+	assert.Equal(t, controller.ActionRolledBack, result.Action)
+	assertLinked(t, cert.HostPath, "server", "server.pem", crts[1])
+	assertLinked(t, cert.HostPath, "server", "server-key.pem", keys[1])
+}
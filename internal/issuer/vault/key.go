@@ -0,0 +1,173 @@
+package vault
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+// Private key algorithms, the algorithm is matched case-insensitively.
+const (
+	keyAlgorithmRSA     = "RSA"
+	keyAlgorithmECDSA   = "ECDSA"
+	keyAlgorithmEd25519 = "ED25519"
+)
+
+// Private key encodings, the encoding is matched case-insensitively.
+const (
+	keyEncodingPKCS1 = "PKCS1"
+	keyEncodingPKCS8 = "PKCS8"
+	keyEncodingSEC1  = "SEC1"
+)
+
+const defaultRSAKeySize = 2048
+
+var curves = map[int]elliptic.Curve{
+	0:   elliptic.P256(),
+	256: elliptic.P256(),
+	384: elliptic.P384(),
+	521: elliptic.P521(),
+}
+
+func keyAlgorithm(spec config.PrivateKey) string {
+	if spec.Algorithm == "" {
+		return keyAlgorithmRSA
+	}
+	return strings.ToUpper(spec.Algorithm)
+}
+
+func validatePrivateKey(spec config.PrivateKey) error {
+	switch keyAlgorithm(spec) {
+	case keyAlgorithmRSA:
+		if spec.Size < 0 {
+			return fmt.Errorf("privateKey: invalid RSA key size %d", spec.Size)
+		}
+	case keyAlgorithmECDSA:
+		if _, isExist := curves[spec.Size]; !isExist {
+			return fmt.Errorf("privateKey: unsupported ECDSA key size %d, expected 256, 384 or 521", spec.Size)
+		}
+	case keyAlgorithmEd25519:
+		if spec.Size != 0 && spec.Size != 256 {
+			return fmt.Errorf("privateKey: Ed25519 key size must be 256")
+		}
+	default:
+		return fmt.Errorf("privateKey: unknown algorithm %s", spec.Algorithm)
+	}
+
+	switch encoding := keyEncoding(spec); {
+	case encoding == keyEncodingPKCS8:
+	case encoding == keyEncodingPKCS1 && keyAlgorithm(spec) == keyAlgorithmRSA:
+	case encoding == keyEncodingSEC1 && keyAlgorithm(spec) == keyAlgorithmECDSA:
+	default:
+		return fmt.Errorf("privateKey: encoding %s is not supported for %s key", spec.Encoding, keyAlgorithm(spec))
+	}
+	return nil
+}
+
+// keyEncoding returns the encoding of the private key spec, the default one is
+// PKCS1 for RSA, SEC1 for ECDSA and PKCS8 for Ed25519.
+func keyEncoding(spec config.PrivateKey) string {
+	if spec.Encoding != "" {
+		return strings.ToUpper(spec.Encoding)
+	}
+	switch keyAlgorithm(spec) {
+	case keyAlgorithmRSA:
+		return keyEncodingPKCS1
+	case keyAlgorithmECDSA:
+		return keyEncodingSEC1
+	}
+	return keyEncodingPKCS8
+}
+
+// generateKey returns a new private key and the CSR signature algorithm matching it.
+func generateKey(spec config.PrivateKey) (crypto.Signer, x509.SignatureAlgorithm, error) {
+	switch keyAlgorithm(spec) {
+	case keyAlgorithmRSA:
+		size := spec.Size
+		if size == 0 {
+			size = defaultRSAKeySize
+		}
+		pk, err := rsa.GenerateKey(rand.Reader, size)
+		return pk, x509.SHA256WithRSA, err
+	case keyAlgorithmECDSA:
+		curve, isExist := curves[spec.Size]
+		if !isExist {
+			return nil, x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported ECDSA key size %d", spec.Size)
+		}
+		pk, err := ecdsa.GenerateKey(curve, rand.Reader)
+		return pk, ecdsaSignatureAlgorithm(curve), err
+	case keyAlgorithmEd25519:
+		_, pk, err := ed25519.GenerateKey(rand.Reader)
+		return pk, x509.PureEd25519, err
+	}
+	return nil, x509.UnknownSignatureAlgorithm, fmt.Errorf("unknown algorithm %s", spec.Algorithm)
+}
+
+func ecdsaSignatureAlgorithm(curve elliptic.Curve) x509.SignatureAlgorithm {
+	switch curve {
+	case elliptic.P384():
+		return x509.ECDSAWithSHA384
+	case elliptic.P521():
+		return x509.ECDSAWithSHA512
+	}
+	return x509.ECDSAWithSHA256
+}
+
+// encodeKey returns PEM of the private key in the encoding.
+func encodeKey(pk crypto.Signer, encoding string) ([]byte, error) {
+	var block *pem.Block
+	switch k := pk.(type) {
+	case *rsa.PrivateKey:
+		if encoding == keyEncodingPKCS1 {
+			block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		}
+	case *ecdsa.PrivateKey:
+		if encoding == keyEncodingSEC1 {
+			der, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+		}
+	}
+
+	if block == nil {
+		if encoding != keyEncodingPKCS8 {
+			return nil, fmt.Errorf("encoding %s is not supported for %T", encoding, pk)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// vaultKeyParams returns key_type and key_bits of Vault for the private key spec.
+func vaultKeyParams(spec config.PrivateKey) (keyType string, keyBits int) {
+	switch keyAlgorithm(spec) {
+	case keyAlgorithmECDSA:
+		keyBits = spec.Size
+		if keyBits == 0 {
+			keyBits = 256
+		}
+		return "ec", keyBits
+	case keyAlgorithmEd25519:
+		return "ed25519", 0
+	}
+	keyBits = spec.Size
+	if keyBits == 0 {
+		keyBits = defaultRSAKeySize
+	}
+	return "rsa", keyBits
+}
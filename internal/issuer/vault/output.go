@@ -0,0 +1,410 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/jceks"
+)
+
+const (
+	outputFormatPKCS12 = "pkcs12"
+	outputFormatJKS    = "jks"
+	outputFormatJCEKS  = "jceks"
+
+	defaultTruststoreAlias = "ca"
+	certificateType        = "X.509"
+)
+
+// Content and encoding of the file outputs.
+const (
+	contentKey   = "key"
+	contentCert  = "cert"
+	contentChain = "chain"
+	contentCA    = "ca"
+
+	encodingPEM = "pem"
+	encodingDER = "der"
+)
+
+// defaultOutputs are the certificate and the key which are always written,
+// the certificate on disk is checked and renewed by them.
+// A file output of the PEM certificate or key replaces the default file.
+func defaultOutputs(cert config.Certificate) []config.Output {
+	return []config.Output{
+		{Path: certificateFile(cert), Content: []string{contentCert}, Encoding: encodingPEM},
+		{Path: keyFile(cert), Content: []string{contentKey}, Encoding: encodingPEM},
+	}
+}
+
+// keyPairOutput returns index of the first file output of the certificate holding only the content in PEM,
+// -1 if there is no such output.
+func keyPairOutput(cert config.Certificate, content string) int {
+	for i, o := range cert.Outputs {
+		if o.Format == "" && o.Encoding != encodingDER && len(o.Content) == 1 && o.Content[0] == content {
+			return i
+		}
+	}
+	return -1
+}
+
+// outputExtensions are extensions of the default output file names by format.
+var outputExtensions = map[string]string{
+	outputFormatPKCS12: ".p12",
+	outputFormatJKS:    ".jks",
+	outputFormatJCEKS:  ".jceks",
+}
+
+func outputName(cert config.Certificate, o config.Output) string {
+	switch {
+	case o.Path != "":
+		return o.Path
+	case o.Truststore:
+		return cert.Name + "-truststore" + outputExtensions[o.Format]
+	}
+	return cert.Name + outputExtensions[o.Format]
+}
+
+// outputAlias returns alias of the key entry or prefix of aliases of the truststore entries.
+func outputAlias(cert config.Certificate, o config.Output) string {
+	switch {
+	case o.Alias != "":
+		return o.Alias
+	case o.Truststore:
+		return defaultTruststoreAlias
+	}
+	return cert.Name
+}
+
+// outputFiles returns the outputs of the certificate except the ones replacing the default files.
+func outputFiles(cert config.Certificate, issued issuedCertificate) []storedFile {
+	r := make([]storedFile, 0, len(cert.Outputs))
+	for i, o := range cert.Outputs {
+		if i == keyPairOutput(cert, contentCert) || i == keyPairOutput(cert, contentKey) {
+			continue
+		}
+		name := outputName(cert, o)
+		r = append(r, storedFile{name: name, data: issued.outputs[name], perm: outputMode(o)})
+	}
+	return r
+}
+
+// outputMode returns the default mode of the output, keystores and files with the key are protected as the key.
+func outputMode(o config.Output) os.FileMode {
+	if o.Format != "" {
+		return keyMode
+	}
+	for _, c := range o.Content {
+		if c == contentKey {
+			return keyMode
+		}
+	}
+	return certificateMode
+}
+
+// encodeFile returns the content of the file output in the order of the config,
+// nil if any content is unknown and the current file is kept.
+func encodeFile(o config.Output, issued issuedCertificate) ([]byte, error) {
+	var r []byte
+	for _, c := range o.Content {
+		var data []byte
+		switch c {
+		case contentKey:
+			data = issued.key
+		case contentCert:
+			data = issued.crt
+		case contentChain:
+			data = issued.chain
+		case contentCA:
+			data = issued.ca
+		default:
+			return nil, fmt.Errorf("unknown content %s", c)
+		}
+		if data == nil {
+			return nil, nil
+		}
+		if len(r) != 0 && r[len(r)-1] != '\n' {
+			r = append(r, '\n')
+		}
+		r = append(r, data...)
+	}
+	if r == nil {
+		r = []byte{}
+	}
+	if o.Encoding != encodingDER {
+		return r, nil
+	}
+
+	block, rest := pem.Decode(r)
+	if block == nil || len(bytes.TrimSpace(rest)) != 0 {
+		return nil, fmt.Errorf("der holds exactly one PEM block")
+	}
+	return block.Bytes, nil
+}
+
+// readPasswords returns the passwords of the keystores by output name.
+// They are read before the certificate is signed, so a missing password does not waste the issued certificate.
+func (s *vault) readPasswords(cert config.Certificate) (map[string]string, error) {
+	r := make(map[string]string)
+	for _, o := range cert.Outputs {
+		if o.Format == "" {
+			continue
+		}
+		name := outputName(cert, o)
+		password, err := s.readPassword(o.PasswordFrom)
+		if err != nil {
+			return nil, fmt.Errorf("read password of %s : %w", name, err)
+		}
+		switch o.Format {
+		case outputFormatJCEKS:
+			err = jceks.ValidatePassword(password)
+		case outputFormatPKCS12:
+			// PKCS#12 passwords are BMP strings
+			if strings.IndexFunc(password, func(c rune) bool { return c > 0xffff }) >= 0 {
+				err = errPasswordIsNotUCS2
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("password of %s : %w", name, err)
+		}
+		r[name] = password
+	}
+	return r, nil
+}
+
+// buildOutputs encodes the keystores with the passwords and the files of the issued certificate.
+func (s *vault) buildOutputs(cert config.Certificate, issued *issuedCertificate, passwords map[string]string) error {
+	if len(cert.Outputs) == 0 {
+		return nil
+	}
+
+	key, err := parsePrivateKey(issued.key)
+	if err != nil {
+		return fmt.Errorf("parse key: %w", err)
+	}
+	crt, err := parseCertificate(issued.crt)
+	if err != nil {
+		return fmt.Errorf("parse certificate: %w", err)
+	}
+	chain, err := parseCertificates(issued.chain)
+	if err != nil {
+		return fmt.Errorf("parse chain: %w", err)
+	}
+	cas, err := parseCertificates(issued.caChain)
+	if err != nil {
+		return fmt.Errorf("parse ca chain: %w", err)
+	}
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshal key: %w", err)
+	}
+
+	issued.outputs = make(map[string][]byte, len(cert.Outputs))
+	for _, o := range cert.Outputs {
+		name := outputName(cert, o)
+		if o.Format == "" {
+			data, err := encodeFile(o, *issued)
+			if err != nil {
+				return fmt.Errorf("encode %s : %w", name, err)
+			}
+			issued.outputs[name] = data
+			continue
+		}
+
+		password := passwords[name]
+
+		// Java keystores hold either the key with the chain or the trusted CAs
+		var (
+			keys    []jceks.PrivateKeyEntry
+			trusted []jceks.TrustedCertificateEntry
+		)
+		if o.Truststore {
+			trusted = trustedEntries(cas, outputAlias(cert, o))
+		} else {
+			keys = []jceks.PrivateKeyEntry{{
+				Alias:            outputAlias(cert, o),
+				PrivateKey:       pkcs8Key,
+				CertificateChain: rawCertificates(append([]*x509.Certificate{crt}, chain...)),
+			}}
+		}
+
+		var data []byte
+		switch {
+		case o.Format == outputFormatPKCS12 && o.Truststore:
+			data, err = pkcs12.Modern.EncodeTrustStore(cas, password)
+		case o.Format == outputFormatPKCS12:
+			data, err = pkcs12.Modern.Encode(key, crt, chain, password)
+		case o.Format == outputFormatJKS:
+			data, err = encodeJKS(keys, trusted, password)
+		case o.Format == outputFormatJCEKS:
+			data, err = jceks.Encode(rand.Reader, keys, trusted, password, time.Now())
+		default:
+			err = fmt.Errorf("unknown format %s", o.Format)
+		}
+		if err != nil {
+			return fmt.Errorf("encode %s : %w", name, err)
+		}
+		issued.outputs[name] = data
+	}
+	return nil
+}
+
+// encodeJKS returns JKS keystore with the entries, keys are protected with the keystore password.
+func encodeJKS(keys []jceks.PrivateKeyEntry, trusted []jceks.TrustedCertificateEntry, password string) ([]byte, error) {
+	ks := keystore.New(keystore.WithOrderedAliases())
+	now := time.Now()
+
+	for _, k := range keys {
+		entry := keystore.PrivateKeyEntry{CreationTime: now, PrivateKey: k.PrivateKey}
+		for _, c := range k.CertificateChain {
+			entry.CertificateChain = append(entry.CertificateChain, keystore.Certificate{Type: certificateType, Content: c})
+		}
+		if err := ks.SetPrivateKeyEntry(k.Alias, entry, []byte(password)); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range trusted {
+		entry := keystore.TrustedCertificateEntry{
+			CreationTime: now,
+			Certificate:  keystore.Certificate{Type: certificateType, Content: t.Certificate},
+		}
+		if err := ks.SetTrustedCertificateEntry(t.Alias, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ks.Store(&buf, []byte(password)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// trustedEntries names the CAs <alias>-0, <alias>-1 and so on from the issuing CA up.
+func trustedEntries(cas []*x509.Certificate, alias string) []jceks.TrustedCertificateEntry {
+	r := make([]jceks.TrustedCertificateEntry, 0, len(cas))
+	for i, c := range cas {
+		r = append(r, jceks.TrustedCertificateEntry{Alias: fmt.Sprintf("%s-%d", alias, i), Certificate: c.Raw})
+	}
+	return r
+}
+
+func rawCertificates(certs []*x509.Certificate) [][]byte {
+	r := make([][]byte, 0, len(certs))
+	for _, c := range certs {
+		r = append(r, c.Raw)
+	}
+	return r
+}
+
+// readPassword returns the password from the file without the trailing newline or from Vault KV secret.
+func (s *vault) readPassword(from config.PasswordFrom) (string, error) {
+	if from.File != "" {
+		data, err := os.ReadFile(from.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	data, err := s.readSecret(config.Secret{Name: from.Secret.Name, Key: from.Secret.Key})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// parseCertificates returns all certificates of PEM data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var r []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return r, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, crt)
+	}
+}
+
+func validateOutputs(outputs []config.Output) error {
+	for _, o := range outputs {
+		if o.Format == "" {
+			if err := validateFileOutput(o); err != nil {
+				return fmt.Errorf("outputs: %w", err)
+			}
+			continue
+		}
+
+		if _, isExist := outputExtensions[o.Format]; !isExist {
+			return fmt.Errorf("outputs: unknown format %s", o.Format)
+		}
+		if len(o.Content) != 0 || o.Encoding != "" {
+			return fmt.Errorf("outputs: content and encoding are not supported for %s", o.Format)
+		}
+
+		if o.Format == outputFormatPKCS12 && o.Alias != "" {
+			return fmt.Errorf("outputs: alias is not supported for %s", o.Format)
+		}
+
+		from := o.PasswordFrom
+		switch {
+		case from.File != "" && from.Secret.Name != "":
+			return fmt.Errorf("outputs: passwordFrom has both file and secret")
+		case from.File == "" && from.Secret.Name == "":
+			return fmt.Errorf("outputs: passwordFrom is required for %s", o.Format)
+		case from.Secret.Name != "" && from.Secret.Key == "":
+			return fmt.Errorf("outputs: passwordFrom secret key is empty")
+		}
+	}
+	return nil
+}
+
+func validateFileOutput(o config.Output) error {
+	if o.Path == "" {
+		return fmt.Errorf("path of file is empty")
+	}
+	if len(o.Content) == 0 {
+		return fmt.Errorf("content of %s is empty", o.Path)
+	}
+	for _, c := range o.Content {
+		switch c {
+		case contentKey, contentCert, contentChain, contentCA:
+		default:
+			return fmt.Errorf("unknown content %s", c)
+		}
+	}
+
+	switch o.Encoding {
+	case "", encodingPEM:
+	case encodingDER:
+		// the chain may hold several CAs which do not fit a single DER certificate
+		if len(o.Content) != 1 || o.Content[0] == contentChain {
+			return fmt.Errorf("der file %s holds a single key, cert or ca", o.Path)
+		}
+	default:
+		return fmt.Errorf("unknown encoding %s", o.Encoding)
+	}
+
+	if o.Alias != "" || o.Truststore || o.PasswordFrom != (config.PasswordFrom{}) {
+		return fmt.Errorf("alias, truststore and passwordFrom are not supported for file %s", o.Path)
+	}
+	return nil
+}
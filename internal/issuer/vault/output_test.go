@@ -0,0 +1,318 @@
+package vault
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+func testPEM(blockType, data string) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: []byte(data)})
+}
+
+func TestEncodeFile(t *testing.T) {
+	var (
+		crt   = testPEM("CERTIFICATE", "crt")
+		key   = testPEM("RSA PRIVATE KEY", "key")
+		ca    = testPEM("CERTIFICATE", "ca")
+		chain = append(testPEM("CERTIFICATE", "ca"), testPEM("CERTIFICATE", "root")...)
+
+		issued = issuedCertificate{crt: crt, key: key, ca: ca, chain: chain}
+	)
+
+	tests := []struct {
+		name    string
+		output  config.Output
+		issued  issuedCertificate
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:   "pem in config order",
+			output: config.Output{Content: []string{contentKey, contentCert, contentChain}},
+			issued: issued,
+			want:   append(append(append([]byte(nil), key...), crt...), chain...),
+		},
+		{
+			name:   "missing newline is added",
+			output: config.Output{Content: []string{contentCert, contentCA}},
+			issued: issuedCertificate{crt: []byte("crt"), ca: ca},
+			want:   append([]byte("crt\n"), ca...),
+		},
+		{
+			name:   "der",
+			output: config.Output{Content: []string{contentCert}, Encoding: encodingDER},
+			issued: issued,
+			want:   []byte("crt"),
+		},
+		{
+			name:    "der of several blocks",
+			output:  config.Output{Content: []string{contentChain}, Encoding: encodingDER},
+			issued:  issued,
+			wantErr: true,
+		},
+		{
+			name:    "der of corrupt pem",
+			output:  config.Output{Content: []string{contentCert}, Encoding: encodingDER},
+			issued:  issuedCertificate{crt: []byte("crt")},
+			wantErr: true,
+		},
+		{
+			name:   "empty chain",
+			output: config.Output{Content: []string{contentChain}},
+			issued: issuedCertificate{crt: crt, key: key, ca: ca, chain: []byte{}},
+			want:   []byte{},
+		},
+		{
+			name:   "not issued keeps the current file",
+			output: config.Output{Content: []string{contentCert, contentKey}},
+			issued: issuedCertificate{crt: crt},
+		},
+		{
+			name:    "unknown content",
+			output:  config.Output{Content: []string{"csr"}},
+			issued:  issued,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encodeFile(tt.output, tt.issued)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, data)
+		})
+	}
+}
+
+func TestValidateOutputs(t *testing.T) {
+	password := config.PasswordFrom{File: "/etc/password"}
+
+	tests := []struct {
+		name    string
+		outputs []config.Output
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			outputs: []config.Output{
+				{Format: outputFormatPKCS12, PasswordFrom: password},
+				{Format: outputFormatJKS, Truststore: true, Alias: "root", PasswordFrom: config.PasswordFrom{Secret: config.SecretKey{Name: "kv", Key: "password"}}},
+				{Path: "server.crt", Content: []string{contentCert}, Encoding: encodingDER},
+				{Path: "server-combined.pem", Content: []string{contentKey, contentCert, contentChain}},
+			},
+		},
+		{
+			name:    "unknown format",
+			outputs: []config.Output{{Format: "p7b", PasswordFrom: password}},
+			wantErr: true,
+		},
+		{
+			name:    "keystore with content",
+			outputs: []config.Output{{Format: outputFormatJCEKS, Content: []string{contentCert}, PasswordFrom: password}},
+			wantErr: true,
+		},
+		{
+			name:    "pkcs12 with alias",
+			outputs: []config.Output{{Format: outputFormatPKCS12, Alias: "server", PasswordFrom: password}},
+			wantErr: true,
+		},
+		{
+			name:    "keystore without password",
+			outputs: []config.Output{{Format: outputFormatJKS}},
+			wantErr: true,
+		},
+		{
+			name:    "password from file and secret",
+			outputs: []config.Output{{Format: outputFormatJKS, PasswordFrom: config.PasswordFrom{File: "/etc/password", Secret: config.SecretKey{Name: "kv", Key: "password"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "password from secret without key",
+			outputs: []config.Output{{Format: outputFormatJKS, PasswordFrom: config.PasswordFrom{Secret: config.SecretKey{Name: "kv"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "file without path",
+			outputs: []config.Output{{Content: []string{contentCert}}},
+			wantErr: true,
+		},
+		{
+			name:    "file without content",
+			outputs: []config.Output{{Path: "server.crt"}},
+			wantErr: true,
+		},
+		{
+			name:    "file of unknown content",
+			outputs: []config.Output{{Path: "server.csr", Content: []string{"csr"}}},
+			wantErr: true,
+		},
+		{
+			name:    "file of unknown encoding",
+			outputs: []config.Output{{Path: "server.crt", Content: []string{contentCert}, Encoding: "base64"}},
+			wantErr: true,
+		},
+		{
+			name:    "der of several contents",
+			outputs: []config.Output{{Path: "server.der", Content: []string{contentKey, contentCert}, Encoding: encodingDER}},
+			wantErr: true,
+		},
+		{
+			name:    "der of chain",
+			outputs: []config.Output{{Path: "chain.der", Content: []string{contentChain}, Encoding: encodingDER}},
+			wantErr: true,
+		},
+		{
+			name:    "file with password",
+			outputs: []config.Output{{Path: "server.crt", Content: []string{contentCert}, PasswordFrom: password}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOutputs(tt.outputs)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestKeyPairFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		outputs     []config.Output
+		wantCert    string
+		wantKey     string
+		wantOutputs []string
+	}{
+		{
+			name:     "default",
+			wantCert: "server.pem",
+			wantKey:  "server-key.pem",
+		},
+		{
+			name: "replaced by pem outputs",
+			outputs: []config.Output{
+				{Path: "tls.crt", Content: []string{contentCert}},
+				{Path: "tls.key", Content: []string{contentKey}, Encoding: encodingPEM},
+				{Path: "copy.crt", Content: []string{contentCert}},
+			},
+			wantCert:    "tls.crt",
+			wantKey:     "tls.key",
+			wantOutputs: []string{"copy.crt"},
+		},
+		{
+			name: "not replaced by der and combined outputs",
+			outputs: []config.Output{
+				{Path: "server.der", Content: []string{contentCert}, Encoding: encodingDER},
+				{Path: "combined.pem", Content: []string{contentKey, contentCert}},
+			},
+			wantCert:    "server.pem",
+			wantKey:     "server-key.pem",
+			wantOutputs: []string{"server.der", "combined.pem"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := config.Certificate{Name: "server", Outputs: tt.outputs}
+			assert.Equal(t, tt.wantCert, certificateFile(cert))
+			assert.Equal(t, tt.wantKey, keyFile(cert))
+
+			var outputs []string
+			for _, f := range outputFiles(cert, issuedCertificate{}) {
+				outputs = append(outputs, f.name)
+			}
+			assert.Equal(t, tt.wantOutputs, outputs)
+		})
+	}
+}
+
+func TestReadPasswords(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name, data string) config.PasswordFrom {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+		return config.PasswordFrom{File: path}
+	}
+	var (
+		plain    = file("plain", "s3cret\n")
+		cyrillic = file("cyrillic", "пароль")
+		emoji    = file("emoji", "s3cret\U0001F511")
+		missing  = config.PasswordFrom{File: filepath.Join(dir, "missing")}
+		secret   = config.PasswordFrom{Secret: config.SecretKey{Name: "keystore", Key: "password"}}
+		absent   = config.PasswordFrom{Secret: config.SecretKey{Name: "keystore", Key: "absent"}}
+	)
+
+	tests := []struct {
+		name    string
+		outputs []config.Output
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "keystores",
+			outputs: []config.Output{
+				{Format: outputFormatPKCS12, PasswordFrom: plain},
+				{Format: outputFormatJKS, PasswordFrom: cyrillic},
+				{Format: outputFormatJCEKS, Truststore: true, PasswordFrom: secret},
+				{Path: "server.crt", Content: []string{contentCert}},
+			},
+			want: map[string]string{
+				"server.p12":              "s3cret",
+				"server.jks":              "пароль",
+				"server-truststore.jceks": "kv-s3cret",
+			},
+		},
+		{
+			name:    "missing file",
+			outputs: []config.Output{{Format: outputFormatPKCS12, PasswordFrom: missing}},
+			wantErr: true,
+		},
+		{
+			name:    "absent secret key",
+			outputs: []config.Output{{Format: outputFormatJKS, PasswordFrom: absent}},
+			wantErr: true,
+		},
+		{
+			name:    "jceks password is not ascii",
+			outputs: []config.Output{{Format: outputFormatJCEKS, PasswordFrom: cyrillic}},
+			wantErr: true,
+		},
+		{
+			name:    "pkcs12 password out of ucs-2",
+			outputs: []config.Output{{Format: outputFormatPKCS12, PasswordFrom: emoji}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, cli := newTestVault(t, newTestCA(t))
+			s.kv = "kv"
+			cli.On("Get", "kv", "keystore").Return(map[string]interface{}{"password": "kv-s3cret"}, nil).Maybe()
+
+			passwords, err := s.readPasswords(config.Certificate{Name: "server", Outputs: tt.outputs})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, passwords)
+		})
+	}
+}
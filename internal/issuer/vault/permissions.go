@@ -0,0 +1,150 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/filesystem"
+)
+
+// permissions are resolved owner and modes of the resource files,
+// negative ids and zero modes keep the defaults.
+type permissions struct {
+	uid, gid int
+	mode     os.FileMode
+	dirMode  os.FileMode
+}
+
+func validatePermissions(p config.Permissions) error {
+	if _, err := parseMode(p.Mode); err != nil {
+		return fmt.Errorf("mode: %w", err)
+	}
+	if _, err := parseMode(p.DirMode); err != nil {
+		return fmt.Errorf("dirMode: %w", err)
+	}
+	return nil
+}
+
+// resolvePermissions looks up owner and group, they may be created after the config is loaded,
+// so they are resolved on every use.
+func resolvePermissions(p config.Permissions) (permissions, error) {
+	r := permissions{uid: -1, gid: -1}
+
+	var err error
+	if r.mode, err = parseMode(p.Mode); err != nil {
+		return r, fmt.Errorf("mode: %w", err)
+	}
+	if r.dirMode, err = parseMode(p.DirMode); err != nil {
+		return r, fmt.Errorf("dirMode: %w", err)
+	}
+
+	if p.Owner != "" {
+		if r.uid, err = lookupID(p.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return r, fmt.Errorf("lookup owner: %w", err)
+		}
+	}
+	if p.Group != "" {
+		if r.gid, err = lookupID(p.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return r, fmt.Errorf("lookup group: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// lookupID returns numeric id as is, otherwise looks up the name.
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid octal mode %s", s)
+	}
+	return os.FileMode(m), nil
+}
+
+func (s permissions) fileMode(def os.FileMode) os.FileMode {
+	if s.mode != 0 {
+		return s.mode
+	}
+	return def
+}
+
+func (s permissions) dirModeOr(def os.FileMode) os.FileMode {
+	if s.dirMode != 0 {
+		return s.dirMode
+	}
+	return def
+}
+
+// enforceCertificate applies permissions to the certificate files on every ensure.
+func enforceCertificate(cert config.Certificate, perm permissions) error {
+	isChanged, err := enforcePermissions(cert.HostPath, cert.Name, certificateFiles(cert, issuedCertificate{}), perm)
+	if err != nil {
+		return fmt.Errorf("enforce permissions: %w", err)
+	}
+	if isChanged {
+		zap.L().Info("permissions", zap.String("resource_type", "certificate"), zap.String("name", cert.Name))
+	}
+	return nil
+}
+
+// enforceSecret applies permissions to the secret file on every ensure.
+func enforceSecret(secret config.Secret, perm permissions) error {
+	isDirChanged, err := perm.applyHostDir(path.Dir(secret.HostPath))
+	if err != nil {
+		return fmt.Errorf("enforce permissions: %w", err)
+	}
+	isChanged, err := perm.apply(secret.HostPath)
+	if err != nil {
+		return fmt.Errorf("enforce permissions: %w", err)
+	}
+	if isChanged || isDirChanged {
+		zap.L().Info("permissions", zap.String("resource_type", "secret"), zap.String("name", secret.Name))
+	}
+	return nil
+}
+
+// apply enforces owner and mode of the file, only explicitly set values are enforced.
+func (s permissions) apply(filepath string) (bool, error) {
+	return filesystem.Apply(filepath, s.uid, s.gid, s.mode)
+}
+
+// applyDir enforces owner and mode of the dir created by key-keeper.
+func (s permissions) applyDir(dir string) (bool, error) {
+	return filesystem.Apply(dir, s.uid, s.gid, s.dirMode)
+}
+
+// applyHostDir enforces only mode of the dir which may be shared with other resources.
+func (s permissions) applyHostDir(dir string) (bool, error) {
+	return filesystem.Apply(dir, -1, -1, s.dirMode)
+}
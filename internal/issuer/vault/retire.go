@@ -0,0 +1,136 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+const (
+	onDeleteKeep    = "keep"
+	onDeleteArchive = "archive"
+	onDeleteDelete  = "delete"
+)
+
+func (s *vault) retireCertificate(cert config.Certificate) error {
+	resourceType := "certificate"
+	if cert.IsCA {
+		resourceType = "intermediate_ca"
+	}
+	logger := zap.L().With(zap.String("resource_type", resourceType), zap.String("name", cert.Name))
+
+	if cert.OnDelete.Revoke {
+		if cert.IsCA {
+			logger.Warn("revoke", zap.Error(fmt.Errorf("revocation of intermediate ca is not supported")))
+		} else if err := s.revokeCertificate(cert); err != nil {
+			logger.Error("revoke", zap.Error(err))
+		} else {
+			logger.Info("revoked")
+		}
+	}
+
+	var files []string
+	for _, f := range certificateFiles(cert, issuedCertificate{}) {
+		files = append(files, path.Join(cert.HostPath, f.name))
+	}
+	dirs := []string{path.Join(cert.HostPath, currentLink(cert.Name))}
+	gens, err := generations(cert.HostPath, cert.Name)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("list generations: %w", err)
+	}
+	for _, g := range gens {
+		dirs = append(dirs, path.Join(cert.HostPath, historyDir, g.name))
+	}
+	if err = cleanup(cert.OnDelete, cert.Name, files, dirs); err != nil {
+		return fmt.Errorf("cleanup with policy %s : %w", cert.OnDelete.Policy, err)
+	}
+	// .history is shared with other certificates of hostPath, it is removed with the last of them
+	if cert.OnDelete.Policy == onDeleteDelete || cert.OnDelete.Policy == onDeleteArchive {
+		_ = os.Remove(path.Join(cert.HostPath, historyDir))
+	}
+	logger.Debug("cleanup", zap.String("policy", cert.OnDelete.Policy))
+	return nil
+}
+
+func (s *vault) retireSecret(secret config.Secret) error {
+	logger := zap.L().With(zap.String("resource_type", "secret"), zap.String("name", secret.Name))
+
+	if err := cleanup(secret.OnDelete, secret.Name, []string{secret.HostPath}, nil); err != nil {
+		return fmt.Errorf("cleanup with policy %s : %w", secret.OnDelete.Policy, err)
+	}
+	logger.Debug("cleanup", zap.String("policy", secret.OnDelete.Policy))
+	return nil
+}
+
+func (s *vault) revokeCertificate(cert config.Certificate) error {
+	crt, err := readCertificate(cert.HostPath, cert)
+	if err != nil {
+		return fmt.Errorf("read certificate: %w", err)
+	}
+
+	vaultPath := path.Join(s.caPath, "revoke")
+	revokeData := map[string]interface{}{
+		"serial_number": serialNumber(crt.SerialNumber.Bytes()),
+	}
+	if _, err = s.cli.Write(vaultPath, revokeData); err != nil {
+		return fmt.Errorf("revoke with vault path %s : %w", vaultPath, err)
+	}
+	return nil
+}
+
+// serialNumber returns serial number in Vault format: hex bytes separated by colons.
+func serialNumber(b []byte) string {
+	parts := make([]string, 0, len(b))
+	for _, v := range b {
+		parts = append(parts, fmt.Sprintf("%02x", v))
+	}
+	return strings.Join(parts, ":")
+}
+
+// cleanup applies onDelete policy to the resource files,
+// dirs hold the internal state of the resource and are removed after the files.
+func cleanup(policy config.OnDelete, name string, files, dirs []string) error {
+	switch policy.Policy {
+	case "", onDeleteKeep:
+		return nil
+	case onDeleteDelete:
+		for _, f := range files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove %s : %w", f, err)
+			}
+		}
+		return removeDirs(dirs)
+	case onDeleteArchive:
+		if policy.ArchiveDir == "" {
+			return fmt.Errorf("archive dir is empty")
+		}
+
+		dir := path.Join(policy.ArchiveDir, fmt.Sprintf("%s-%s", name, time.Now().Format("20060102T150405")))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("mkdir all %s : %w", dir, err)
+		}
+
+		for _, f := range files {
+			if err := moveFile(f, path.Join(dir, path.Base(f))); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("move %s to %s : %w", f, dir, err)
+			}
+		}
+		return removeDirs(dirs)
+	}
+	return fmt.Errorf("unknown policy %s", policy.Policy)
+}
+
+func removeDirs(dirs []string) error {
+	for _, d := range dirs {
+		if err := os.RemoveAll(d); err != nil {
+			return fmt.Errorf("remove %s : %w", d, err)
+		}
+	}
+	return nil
+}
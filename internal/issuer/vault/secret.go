@@ -1,24 +1,43 @@
 package vault
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path"
 
 	"go.uber.org/zap"
 
 	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/controller"
+	"github.com/fraima/key-keeper/internal/filesystem"
 )
 
-func (s *vault) ensureSecret(i config.Secret) {
+func (s *vault) ensureSecret(i config.Secret, perm permissions) (controller.Action, error) {
 	logger := zap.L().With(zap.String("resource_type", "secret"), zap.String("name", i.Name))
 
 	secret, err := s.readSecret(i)
 	if err != nil {
-		logger.Warn("read", zap.Error(err))
+		return controller.ActionFailed, fmt.Errorf("read: %w", err)
 	}
 
-	if err = writeToFile(i.HostPath, secret); err != nil {
-		zap.L().Error("store", zap.String("path", i.HostPath), zap.Error(err))
+	action := controller.ActionRenewed
+	stored, err := os.ReadFile(i.HostPath)
+	switch {
+	case err == nil && bytes.Equal(stored, secret):
+		return controller.ActionNone, nil
+	case os.IsNotExist(err):
+		action = controller.ActionIssued
 	}
+
+	if err = os.MkdirAll(path.Dir(i.HostPath), perm.dirModeOr(hostDirMode)); err != nil {
+		return controller.ActionFailed, fmt.Errorf("mkdir all %s : %w", path.Dir(i.HostPath), err)
+	}
+	if err = filesystem.WriteFileAs(i.HostPath, secret, perm.fileMode(secretMode), perm.uid, perm.gid); err != nil {
+		return controller.ActionFailed, fmt.Errorf("store %s : %w", i.HostPath, err)
+	}
+	logger.Debug("stored")
+	return action, nil
 }
 
 func (s *vault) readSecret(i config.Secret) ([]byte, error) {
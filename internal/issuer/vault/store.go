@@ -0,0 +1,300 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/filesystem"
+)
+
+// Files of a certificate are written to a new generation dir hostPath/.history/<serial>,
+// then the link hostPath/.history/<name>.current is switched to it at once,
+// hostPath/<file> are links to .history/<name>.current/<file>.
+// So readers never see a truncated file or a certificate with the key of another generation.
+// Certificates of the same hostPath share .history, a generation is owned by the certificate named in its ownerFile.
+const (
+	historyDir    = ".history"
+	currentSuffix = ".current"
+	ownerFile     = ".owner"
+)
+
+// Default modes of the certificate files and dirs.
+const (
+	certificateMode   os.FileMode = 0644
+	keyMode           os.FileMode = 0600
+	secretMode        os.FileMode = 0644
+	hostDirMode       os.FileMode = 0777
+	generationDirMode os.FileMode = 0755
+)
+
+// storedFile is a file of the certificate generation.
+type storedFile struct {
+	name string
+	data []byte
+	perm os.FileMode
+}
+
+// currentLink returns the link to the current generation of the certificate relative to hostPath.
+func currentLink(name string) string {
+	return path.Join(historyDir, name+currentSuffix)
+}
+
+// generationName returns the serial number without colons which names the generation dir.
+func generationName(serial string) string {
+	return strings.ToLower(strings.ReplaceAll(serial, ":", ""))
+}
+
+// certificateFile returns the file name of the certificate in hostPath,
+// the path of the file output of the PEM certificate replaces the default <name>.pem.
+func certificateFile(cert config.Certificate) string {
+	if i := keyPairOutput(cert, contentCert); i >= 0 {
+		return cert.Outputs[i].Path
+	}
+	return cert.Name + ".pem"
+}
+
+// keyFile returns the file name of the private key in hostPath,
+// the path of the file output of the PEM key replaces the default <name>-key.pem.
+func keyFile(cert config.Certificate) string {
+	if i := keyPairOutput(cert, contentKey); i >= 0 {
+		return cert.Outputs[i].Path
+	}
+	return cert.Name + "-key.pem"
+}
+
+// storeKeyPair writes certificate and key and reports whether they differ from the files on disk.
+// Nil certificate or key keeps the current file.
+func storeKeyPair(cert config.Certificate, crt, key []byte, perm permissions) (bool, error) {
+	return storeFiles(cert, keyPairFiles(cert, crt, key), perm)
+}
+
+// keyPairFiles returns the default outputs, nil certificate or key refers to the current file.
+func keyPairFiles(cert config.Certificate, crt, key []byte) []storedFile {
+	issued := issuedCertificate{crt: crt, key: key}
+
+	outputs := defaultOutputs(cert)
+	r := make([]storedFile, 0, len(outputs))
+	for _, o := range outputs {
+		// PEM file of a single content is never failed
+		data, _ := encodeFile(o, issued)
+		r = append(r, storedFile{name: o.Path, data: data, perm: outputMode(o)})
+	}
+	return r
+}
+
+// storeFiles writes a new generation of the files if any of them differs from the current one
+// and reports whether the content was changed. Files with nil data are taken from the current generation.
+// Owner and mode are set before the generation becomes current,
+// the previous generations are kept according to the history settings.
+func storeFiles(cert config.Certificate, files []storedFile, perm permissions) (bool, error) {
+	hostPath, name := cert.HostPath, cert.Name
+	if err := os.MkdirAll(hostPath, perm.dirModeOr(hostDirMode)); err != nil {
+		return false, fmt.Errorf("mkdir all %s : %w", hostPath, err)
+	}
+	if _, err := perm.applyHostDir(hostPath); err != nil {
+		return false, fmt.Errorf("set permissions of %s : %w", hostPath, err)
+	}
+
+	var (
+		isChanged bool
+		isLinked  = true
+		stored    = make([]storedFile, 0, len(files))
+	)
+	for _, f := range files {
+		current, err := os.ReadFile(path.Join(hostPath, f.name))
+		switch {
+		case f.data == nil && err == nil:
+			f.data = current
+		case f.data == nil:
+			continue
+		case err != nil || !bytes.Equal(current, f.data):
+			isChanged = true
+		}
+		if target, err := os.Readlink(path.Join(hostPath, f.name)); err != nil || target != linkTarget(name, f.name) {
+			isLinked = false
+		}
+		stored = append(stored, f)
+	}
+	if !isChanged && isLinked {
+		return false, nil
+	}
+
+	gen, err := storedGeneration(cert, stored)
+	if err != nil {
+		return false, err
+	}
+	dir := path.Join(hostPath, historyDir, gen)
+	owner, err := generationOwner(dir)
+	if err == nil && owner != name {
+		return false, fmt.Errorf("generation %s is owned by %s", gen, owner)
+	}
+	isNew := err != nil
+	if err = os.MkdirAll(path.Join(hostPath, historyDir), generationDirMode); err != nil {
+		return false, fmt.Errorf("mkdir all %s : %w", historyDir, err)
+	}
+	if err = os.MkdirAll(dir, perm.dirModeOr(generationDirMode)); err != nil {
+		return false, fmt.Errorf("mkdir all %s : %w", dir, err)
+	}
+	if _, err = perm.applyDir(dir); err != nil {
+		return false, fmt.Errorf("set permissions of %s : %w", dir, err)
+	}
+	for _, f := range stored {
+		if err = filesystem.WriteFileAs(path.Join(dir, f.name), f.data, perm.fileMode(f.perm), perm.uid, perm.gid); err != nil {
+			return false, fmt.Errorf("write %s : %w", f.name, err)
+		}
+	}
+	// the owner file is written last and once, its modification time orders the generations
+	if isNew {
+		if err = filesystem.WriteFileAs(path.Join(dir, ownerFile), []byte(name), certificateMode, perm.uid, perm.gid); err != nil {
+			return false, fmt.Errorf("write %s : %w", ownerFile, err)
+		}
+	}
+
+	if err = switchGeneration(hostPath, name, gen, stored); err != nil {
+		return isChanged, err
+	}
+
+	if err = pruneGenerations(hostPath, name, gen, cert.History, time.Now()); err != nil {
+		return isChanged, fmt.Errorf("prune generations: %w", err)
+	}
+	return isChanged, nil
+}
+
+// storedGeneration returns the generation name of the stored certificate.
+func storedGeneration(cert config.Certificate, files []storedFile) (string, error) {
+	for _, f := range files {
+		if f.name != certificateFile(cert) {
+			continue
+		}
+		crt, err := parseCertificate(f.data)
+		if err != nil {
+			return "", fmt.Errorf("parse certificate: %w", err)
+		}
+		return generationName(serialNumber(crt.SerialNumber.Bytes())), nil
+	}
+	return "", fmt.Errorf("certificate is missing")
+}
+
+// switchGeneration makes the generation current and links the files in hostPath to it.
+func switchGeneration(hostPath, name, gen string, files []storedFile) error {
+	if err := filesystem.Symlink(gen, path.Join(hostPath, currentLink(name))); err != nil {
+		return fmt.Errorf("switch generation: %w", err)
+	}
+	for _, f := range files {
+		link := path.Join(hostPath, f.name)
+		if target, err := os.Readlink(link); err == nil && target == linkTarget(name, f.name) {
+			continue
+		}
+		if err := filesystem.Symlink(linkTarget(name, f.name), link); err != nil {
+			return fmt.Errorf("link %s : %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// enforcePermissions applies the explicitly set owner and modes to the current files
+// and reports whether anything was changed.
+func enforcePermissions(hostPath, name string, files []storedFile, perm permissions) (bool, error) {
+	var isChanged bool
+	apply := func(target string, apply func(string) (bool, error)) error {
+		changed, err := apply(target)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		isChanged = isChanged || changed
+		return err
+	}
+
+	if err := apply(hostPath, perm.applyHostDir); err != nil {
+		return isChanged, err
+	}
+	// .history is shared with other certificates, so only the current generation dir is owned by the certificate
+	if err := apply(path.Join(hostPath, currentLink(name)), perm.applyDir); err != nil {
+		return isChanged, err
+	}
+	for _, f := range files {
+		if err := apply(path.Join(hostPath, f.name), perm.apply); err != nil {
+			return isChanged, err
+		}
+	}
+	return isChanged, nil
+}
+
+// linkTarget returns the target of hostPath/<file> link relative to hostPath.
+func linkTarget(name, file string) string {
+	return path.Join(currentLink(name), file)
+}
+
+type generation struct {
+	name    string
+	created time.Time
+}
+
+// generations returns generations of the certificate from the oldest to the newest.
+func generations(hostPath, name string) ([]generation, error) {
+	genDir := path.Join(hostPath, historyDir)
+	entries, err := os.ReadDir(genDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var r []generation
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := path.Join(genDir, e.Name())
+		if owner, err := generationOwner(dir); err != nil || owner != name {
+			continue
+		}
+		info, err := os.Stat(path.Join(dir, ownerFile))
+		if err != nil {
+			continue
+		}
+		r = append(r, generation{name: e.Name(), created: info.ModTime()})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if !r[i].created.Equal(r[j].created) {
+			return r[i].created.Before(r[j].created)
+		}
+		return r[i].name < r[j].name
+	})
+	return r, nil
+}
+
+// generationOwner returns name of the certificate which owns the generation dir.
+func generationOwner(dir string) (string, error) {
+	data, err := os.ReadFile(path.Join(dir, ownerFile))
+	return string(data), err
+}
+
+// pruneGenerations removes generations of the certificate except the current one and
+// the newest history.limit ones which are not older than history.maxAge.
+func pruneGenerations(hostPath, name, current string, history config.History, now time.Time) error {
+	gens, err := generations(hostPath, name)
+	if err != nil {
+		return err
+	}
+
+	var kept int
+	for i := len(gens) - 1; i >= 0; i-- {
+		gen := gens[i]
+		if gen.name == current {
+			continue
+		}
+		if kept < history.Limit && (history.MaxAge == 0 || now.Sub(gen.created) <= history.MaxAge) {
+			kept++
+			continue
+		}
+		if err = os.RemoveAll(path.Join(hostPath, historyDir, gen.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
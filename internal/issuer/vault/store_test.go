@@ -0,0 +1,289 @@
+package vault
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+// storeTestCertificate issues a new certificate and stores it as a new generation.
+func storeTestCertificate(t *testing.T, ca *testCA, cert config.Certificate) (crt, key []byte) {
+	t.Helper()
+	crt, key = ca.issue(t, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	isChanged, err := storeKeyPair(cert, crt, key, testPermissions)
+	require.NoError(t, err)
+	require.True(t, isChanged)
+	return crt, key
+}
+
+// testGeneration returns the generation name of the certificate.
+func testGeneration(t *testing.T, crt []byte) string {
+	t.Helper()
+	c, err := parseCertificate(crt)
+	require.NoError(t, err)
+	return generationName(serialNumber(c.SerialNumber.Bytes()))
+}
+
+func testPEMCertificate(t *testing.T, ca *testCA) []byte {
+	t.Helper()
+	crt, _ := ca.issue(t, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	return crt
+}
+
+func generationNames(t *testing.T, hostPath, name string) []string {
+	t.Helper()
+	gens, err := generations(hostPath, name)
+	require.NoError(t, err)
+	var r []string
+	for _, g := range gens {
+		r = append(r, g.name)
+	}
+	return r
+}
+
+func assertLinked(t *testing.T, hostPath, name, file string, data []byte) {
+	t.Helper()
+	target, err := os.Readlink(filepath.Join(hostPath, file))
+	require.NoError(t, err)
+	assert.Equal(t, linkTarget(name, file), target)
+	got, err := os.ReadFile(filepath.Join(hostPath, file))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestStoreFilesMigratesRegularFiles(t *testing.T) {
+	ca := newTestCA(t)
+	crt, key := ca.issue(t, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name string
+		crt  []byte
+		key  []byte
+		// wantChanged is false if the content of the regular files is kept
+		wantChanged bool
+	}{
+		{
+			name: "current files are kept",
+		},
+		{
+			name: "same content",
+			crt:  crt,
+			key:  key,
+		},
+		{
+			name:        "new content",
+			crt:         testPEMCertificate(t, ca),
+			key:         []byte("new key\n"),
+			wantChanged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := config.Certificate{Name: "server", HostPath: t.TempDir()}
+			require.NoError(t, os.WriteFile(filepath.Join(cert.HostPath, "server.pem"), crt, 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(cert.HostPath, "server-key.pem"), key, 0600))
+
+			isChanged, err := storeKeyPair(cert, tt.crt, tt.key, testPermissions)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantChanged, isChanged)
+
+			wantCrt, wantKey := crt, key
+			if tt.wantChanged {
+				wantCrt, wantKey = tt.crt, tt.key
+			}
+			assertLinked(t, cert.HostPath, "server", "server.pem", wantCrt)
+			assertLinked(t, cert.HostPath, "server", "server-key.pem", wantKey)
+			assert.Equal(t, []string{testGeneration(t, wantCrt)}, generationNames(t, cert.HostPath, "server"))
+
+			info, err := os.Stat(filepath.Join(cert.HostPath, "server-key.pem"))
+			require.NoError(t, err)
+			assert.Equal(t, keyMode, info.Mode().Perm())
+
+			// linked files are not stored again
+			isChanged, err = storeKeyPair(cert, nil, nil, testPermissions)
+			require.NoError(t, err)
+			assert.False(t, isChanged)
+		})
+	}
+}
+
+func TestStoreFilesConcurrentReads(t *testing.T) {
+	ca := newTestCA(t)
+	cert := config.Certificate{Name: "server", HostPath: t.TempDir(), History: config.History{Limit: 1}}
+	first, _ := storeTestCertificate(t, ca, cert)
+
+	var (
+		mu     sync.Mutex
+		stored = [][]byte{first}
+		stop   = make(chan struct{})
+		wg     sync.WaitGroup
+	)
+	isStored := func(data []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, s := range stored {
+			if bytes.Equal(s, data) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			data, err := os.ReadFile(filepath.Join(cert.HostPath, "server.pem"))
+			if err == nil && !isStored(data) {
+				err = os.ErrInvalid
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		crt, key := ca.issue(t, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+		mu.Lock()
+		stored = append(stored, crt)
+		mu.Unlock()
+		_, err := storeKeyPair(cert, crt, key, testPermissions)
+		require.NoError(t, err)
+	}
+	close(stop)
+	wg.Wait()
+
+	// a reader sees a complete certificate of some generation at any moment
+	assert.NoError(t, readErr)
+	assert.Len(t, generationNames(t, cert.HostPath, "server"), 2)
+}
+
+func TestStoreFilesSharedHostPath(t *testing.T) {
+	var (
+		ca       = newTestCA(t)
+		hostPath = t.TempDir()
+		a        = config.Certificate{Name: "a", HostPath: hostPath}
+		b        = config.Certificate{Name: "b", HostPath: hostPath, History: config.History{Limit: 5}}
+	)
+
+	crtA, keyA := storeTestCertificate(t, ca, a)
+	crtB1, _ := storeTestCertificate(t, ca, b)
+	crtB2, keyB2 := storeTestCertificate(t, ca, b)
+	// the generations of b are not pruned with the history of a
+	crtA2, keyA2 := storeTestCertificate(t, ca, a)
+
+	assert.Equal(t, []string{testGeneration(t, crtA2)}, generationNames(t, hostPath, "a"))
+	assert.Equal(t, []string{testGeneration(t, crtB1), testGeneration(t, crtB2)}, generationNames(t, hostPath, "b"))
+	assert.NotEqual(t, crtA, crtA2)
+	assert.NotEqual(t, keyA, keyA2)
+
+	assertLinked(t, hostPath, "a", "a.pem", crtA2)
+	assertLinked(t, hostPath, "a", "a-key.pem", keyA2)
+	assertLinked(t, hostPath, "b", "b.pem", crtB2)
+	assertLinked(t, hostPath, "b", "b-key.pem", keyB2)
+}
+
+func TestStoreFilesGenerationOfAnotherCertificate(t *testing.T) {
+	var (
+		ca       = newTestCA(t)
+		hostPath = t.TempDir()
+		cert     = config.Certificate{Name: "a", HostPath: hostPath}
+	)
+	crt, key := ca.issue(t, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+
+	dir := filepath.Join(hostPath, historyDir, testGeneration(t, crt))
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ownerFile), []byte("b"), 0644))
+
+	_, err := storeKeyPair(cert, crt, key, testPermissions)
+	assert.Error(t, err)
+	_, err = os.Lstat(filepath.Join(hostPath, "a.pem"))
+	assert.True(t, os.IsNotExist(err), "err %v", err)
+	owner, err := generationOwner(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "b", owner)
+}
+
+func TestPruneGenerations(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		history config.History
+		// ages of the generations from the oldest, the last one is current
+		ages []time.Duration
+		want []int
+	}{
+		{
+			name: "none kept",
+			ages: []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour},
+			want: []int{2},
+		},
+		{
+			name:    "limit",
+			history: config.History{Limit: 1},
+			ages:    []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour},
+			want:    []int{1, 2},
+		},
+		{
+			name:    "max age",
+			history: config.History{Limit: 5, MaxAge: 150 * time.Minute},
+			ages:    []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour},
+			want:    []int{1, 2},
+		},
+		{
+			name:    "current older than max age",
+			history: config.History{Limit: 5, MaxAge: time.Hour},
+			ages:    []time.Duration{3 * time.Hour, 2 * time.Hour, 90 * time.Minute, 2 * time.Hour},
+			want:    []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostPath := t.TempDir()
+			var names []string
+			for i, age := range tt.ages {
+				gen := string(rune('a' + i))
+				dir := filepath.Join(hostPath, historyDir, gen)
+				require.NoError(t, os.MkdirAll(dir, 0755))
+				owner := filepath.Join(dir, ownerFile)
+				require.NoError(t, os.WriteFile(owner, []byte("server"), 0644))
+				require.NoError(t, os.Chtimes(owner, now.Add(-age), now.Add(-age)))
+				names = append(names, gen)
+			}
+			// a generation of another certificate is never pruned
+			other := filepath.Join(hostPath, historyDir, "z")
+			require.NoError(t, os.MkdirAll(other, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(other, ownerFile), []byte("other"), 0644))
+
+			current := names[len(names)-1]
+			require.NoError(t, pruneGenerations(hostPath, "server", current, tt.history, now))
+
+			var want []string
+			for _, i := range tt.want {
+				want = append(want, names[i])
+			}
+			got := generationNames(t, hostPath, "server")
+			assert.ElementsMatch(t, want, got)
+			assert.Equal(t, []string{"z"}, generationNames(t, hostPath, "other"))
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package vault
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+type extKeyUsage struct {
+	usage x509.ExtKeyUsage
+	oid   asn1.ObjectIdentifier
+	vault string
+}
+
+// keyUsages are names of key usages in .spec.usage, the names are matched case-insensitively.
+var keyUsages = map[string]x509.KeyUsage{
+	"signing":            x509.KeyUsageDigitalSignature,
+	"digital signature":  x509.KeyUsageDigitalSignature,
+	"content commitment": x509.KeyUsageContentCommitment,
+	"key encipherment":   x509.KeyUsageKeyEncipherment,
+	"data encipherment":  x509.KeyUsageDataEncipherment,
+	"key agreement":      x509.KeyUsageKeyAgreement,
+	"cert sign":          x509.KeyUsageCertSign,
+	"crl sign":           x509.KeyUsageCRLSign,
+	"encipher only":      x509.KeyUsageEncipherOnly,
+	"decipher only":      x509.KeyUsageDecipherOnly,
+}
+
+// vaultKeyUsages are names of key usages in Vault.
+var vaultKeyUsages = map[x509.KeyUsage]string{
+	x509.KeyUsageDigitalSignature:  "DigitalSignature",
+	x509.KeyUsageContentCommitment: "ContentCommitment",
+	x509.KeyUsageKeyEncipherment:   "KeyEncipherment",
+	x509.KeyUsageDataEncipherment:  "DataEncipherment",
+	x509.KeyUsageKeyAgreement:      "KeyAgreement",
+	x509.KeyUsageCertSign:          "CertSign",
+	x509.KeyUsageCRLSign:           "CRLSign",
+	x509.KeyUsageEncipherOnly:      "EncipherOnly",
+	x509.KeyUsageDecipherOnly:      "DecipherOnly",
+}
+
+// extKeyUsages are names of extended key usages in .spec.usage, the names are matched case-insensitively.
+var extKeyUsages = map[string]extKeyUsage{
+	"any":              {x509.ExtKeyUsageAny, asn1.ObjectIdentifier{2, 5, 29, 37, 0}, "Any"},
+	"server auth":      {x509.ExtKeyUsageServerAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}, "ServerAuth"},
+	"client auth":      {x509.ExtKeyUsageClientAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}, "ClientAuth"},
+	"code signing":     {x509.ExtKeyUsageCodeSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}, "CodeSigning"},
+	"email protection": {x509.ExtKeyUsageEmailProtection, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}, "EmailProtection"},
+	"s/mime":           {x509.ExtKeyUsageEmailProtection, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}, "EmailProtection"},
+	"ipsec end system": {x509.ExtKeyUsageIPSECEndSystem, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 5}, "IPSECEndSystem"},
+	"ipsec tunnel":     {x509.ExtKeyUsageIPSECTunnel, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 6}, "IPSECTunnel"},
+	"ipsec user":       {x509.ExtKeyUsageIPSECUser, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 7}, "IPSECUser"},
+	"timestamping":     {x509.ExtKeyUsageTimeStamping, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}, "TimeStamping"},
+	"ocsp signing":     {x509.ExtKeyUsageOCSPSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}, "OCSPSigning"},
+	"microsoft sgc":    {x509.ExtKeyUsageMicrosoftServerGatedCrypto, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 3, 3}, "MicrosoftServerGatedCrypto"},
+	"netscape sgc":     {x509.ExtKeyUsageNetscapeServerGatedCrypto, asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 4, 1}, "NetscapeServerGatedCrypto"},
+}
+
+var (
+	oidExtensionKeyUsage    = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+// usages is a parsed .spec.usage.
+type usages struct {
+	keyUsage    x509.KeyUsage
+	extKeyUsage []extKeyUsage
+}
+
+func parseUsages(src []string) (usages, error) {
+	var r usages
+	seen := make(map[x509.ExtKeyUsage]struct{})
+	for _, u := range src {
+		name := strings.ToLower(strings.TrimSpace(u))
+		if ku, isExist := keyUsages[name]; isExist {
+			r.keyUsage |= ku
+			continue
+		}
+		eku, isExist := extKeyUsages[name]
+		if !isExist {
+			return usages{}, fmt.Errorf("usage: unknown usage %s", u)
+		}
+		if _, isSeen := seen[eku.usage]; !isSeen {
+			seen[eku.usage] = struct{}{}
+			r.extKeyUsage = append(r.extKeyUsage, eku)
+		}
+	}
+	return r, nil
+}
+
+// vaultParams returns key_usage and ext_key_usage of the Vault sign request.
+func (s usages) vaultParams() (keyUsage, extKeyUsage []string) {
+	for ku := x509.KeyUsageDigitalSignature; ku <= x509.KeyUsageDecipherOnly; ku <<= 1 {
+		if s.keyUsage&ku != 0 {
+			keyUsage = append(keyUsage, vaultKeyUsages[ku])
+		}
+	}
+	for _, eku := range s.extKeyUsage {
+		extKeyUsage = append(extKeyUsage, eku.vault)
+	}
+	return
+}
+
+// extensions returns key usage and extended key usage extensions of the CSR.
+func (s usages) extensions() ([]pkix.Extension, error) {
+	var r []pkix.Extension
+	if s.keyUsage != 0 {
+		value, err := marshalKeyUsage(s.keyUsage)
+		if err != nil {
+			return nil, fmt.Errorf("marshal key usage: %w", err)
+		}
+		r = append(r, pkix.Extension{Id: oidExtensionKeyUsage, Critical: true, Value: value})
+	}
+	if len(s.extKeyUsage) != 0 {
+		oids := make([]asn1.ObjectIdentifier, 0, len(s.extKeyUsage))
+		for _, eku := range s.extKeyUsage {
+			oids = append(oids, eku.oid)
+		}
+		value, err := asn1.Marshal(oids)
+		if err != nil {
+			return nil, fmt.Errorf("marshal ext key usage: %w", err)
+		}
+		r = append(r, pkix.Extension{Id: oidExtensionExtKeyUsage, Value: value})
+	}
+	return r, nil
+}
+
+// verify returns error if the certificate does not carry the usages.
+func (s usages) verify(crt *x509.Certificate) error {
+	for ku := x509.KeyUsageDigitalSignature; ku <= x509.KeyUsageDecipherOnly; ku <<= 1 {
+		if s.keyUsage&ku != 0 && crt.KeyUsage&ku == 0 {
+			return fmt.Errorf("certificate lacks key usage %s", vaultKeyUsages[ku])
+		}
+	}
+	for _, eku := range s.extKeyUsage {
+		if !hasExtKeyUsage(crt, eku.usage) {
+			return fmt.Errorf("certificate lacks ext key usage %s", eku.vault)
+		}
+	}
+	return nil
+}
+
+func hasExtKeyUsage(crt *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range crt.ExtKeyUsage {
+		if u == usage || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalKeyUsage encodes key usage as ASN.1 BIT STRING, bit 0 is the most significant bit of the first byte.
+func marshalKeyUsage(ku x509.KeyUsage) ([]byte, error) {
+	b := []byte{bits.Reverse8(byte(ku)), bits.Reverse8(byte(ku >> 8))}
+	if b[1] == 0 {
+		b = b[:1]
+	}
+	return asn1.Marshal(asn1.BitString{
+		Bytes:     b,
+		BitLength: len(b)*8 - bits.TrailingZeros8(b[len(b)-1]),
+	})
+}
@@ -0,0 +1,291 @@
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testExtension returns the value of the extension with the oid.
+func testExtension(t *testing.T, extensions []pkix.Extension, oid asn1.ObjectIdentifier) pkix.Extension {
+	t.Helper()
+	for _, ext := range extensions {
+		if ext.Id.Equal(oid) {
+			return ext
+		}
+	}
+	require.Failf(t, "extension is not found", "oid %s", oid)
+	return pkix.Extension{}
+}
+
+// testCertificateOf returns the certificate signed by Go with the usages of the template or the extensions.
+func testCertificateOf(t *testing.T, ku x509.KeyUsage, eku []x509.ExtKeyUsage, extensions []pkix.Extension) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "test"},
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(time.Hour),
+		KeyUsage:        ku,
+		ExtKeyUsage:     eku,
+		ExtraExtensions: extensions,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	crt, err := x509.ParseCertificate(raw)
+	require.NoError(t, err)
+	return crt
+}
+
+func TestMarshalKeyUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		ku   x509.KeyUsage
+	}{
+		{name: "digital signature", ku: x509.KeyUsageDigitalSignature},
+		{name: "content commitment", ku: x509.KeyUsageContentCommitment},
+		{name: "key encipherment", ku: x509.KeyUsageKeyEncipherment},
+		{name: "data encipherment", ku: x509.KeyUsageDataEncipherment},
+		{name: "key agreement", ku: x509.KeyUsageKeyAgreement},
+		{name: "cert sign", ku: x509.KeyUsageCertSign},
+		{name: "crl sign", ku: x509.KeyUsageCRLSign},
+		{name: "encipher only", ku: x509.KeyUsageEncipherOnly},
+		{name: "decipher only", ku: x509.KeyUsageDecipherOnly},
+		{name: "server", ku: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{name: "ca", ku: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign},
+		{name: "key agreement and decipher only", ku: x509.KeyUsageKeyAgreement | x509.KeyUsageDecipherOnly},
+		{name: "all", ku: x509.KeyUsageDigitalSignature<<9 - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := marshalKeyUsage(tt.ku)
+			require.NoError(t, err)
+
+			// the encoding is the same as the one of the certificate signed by Go
+			crt := testCertificateOf(t, tt.ku, nil, nil)
+			assert.Equal(t, testExtension(t, crt.Extensions, oidExtensionKeyUsage).Value, value)
+
+			var bs asn1.BitString
+			_, err = asn1.Unmarshal(value, &bs)
+			require.NoError(t, err)
+			for bit := 0; bit < 9; bit++ {
+				assert.Equal(t, tt.ku&(1<<bit) != 0, bs.At(bit) == 1, "bit %d", bit)
+			}
+		})
+	}
+}
+
+func TestUsagesExtensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		usage   []string
+		wantKU  x509.KeyUsage
+		wantEKU []x509.ExtKeyUsage
+	}{
+		{
+			name:    "server",
+			usage:   []string{"digital signature", "key encipherment", "server auth"},
+			wantKU:  x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			wantEKU: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name:   "key usage only",
+			usage:  []string{"cert sign", "crl sign", "decipher only"},
+			wantKU: x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDecipherOnly,
+		},
+		{
+			name:    "ext key usage only",
+			usage:   []string{"client auth", "code signing", "ocsp signing", "microsoft sgc"},
+			wantEKU: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageOCSPSigning, x509.ExtKeyUsageMicrosoftServerGatedCrypto},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseUsages(tt.usage)
+			require.NoError(t, err)
+			extensions, err := u.extensions()
+			require.NoError(t, err)
+
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			require.NoError(t, err)
+			der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+				Subject:         pkix.Name{CommonName: "test"},
+				ExtraExtensions: extensions,
+			}, key)
+			require.NoError(t, err)
+			csr, err := x509.ParseCertificateRequest(der)
+			require.NoError(t, err)
+			assert.Equal(t, extensions, csr.Extensions)
+			if tt.wantKU != 0 {
+				assert.True(t, testExtension(t, csr.Extensions, oidExtensionKeyUsage).Critical)
+			}
+
+			// the extensions of the CSR copied to the certificate are parsed as the usages
+			crt := testCertificateOf(t, 0, nil, csr.Extensions)
+			assert.Equal(t, tt.wantKU, crt.KeyUsage)
+			assert.Equal(t, tt.wantEKU, crt.ExtKeyUsage)
+			assert.NoError(t, u.verify(crt))
+		})
+	}
+}
+
+func TestParseUsages(t *testing.T) {
+	tests := []struct {
+		name    string
+		usage   []string
+		want    usages
+		wantErr bool
+	}{
+		{
+			name:  "case and spaces",
+			usage: []string{" Digital Signature", "KEY ENCIPHERMENT ", "Server Auth"},
+			want: usages{
+				keyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+				extKeyUsage: []extKeyUsage{extKeyUsages["server auth"]},
+			},
+		},
+		{
+			name:  "aliases",
+			usage: []string{"signing", "digital signature", "email protection", "s/mime"},
+			want: usages{
+				keyUsage:    x509.KeyUsageDigitalSignature,
+				extKeyUsage: []extKeyUsage{extKeyUsages["email protection"]},
+			},
+		},
+		{
+			name:  "ext key usage order is kept",
+			usage: []string{"client auth", "server auth", "client auth"},
+			want: usages{
+				extKeyUsage: []extKeyUsage{extKeyUsages["client auth"], extKeyUsages["server auth"]},
+			},
+		},
+		{
+			name: "empty",
+		},
+		{
+			name:    "unknown usage",
+			usage:   []string{"server auth", "server authentication"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseUsages(tt.usage)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, u)
+		})
+	}
+}
+
+func TestUsagesVaultParams(t *testing.T) {
+	tests := []struct {
+		name            string
+		usage           []string
+		wantKeyUsage    []string
+		wantExtKeyUsage []string
+	}{
+		{
+			name:            "in bit order",
+			usage:           []string{"decipher only", "key encipherment", "digital signature", "client auth", "server auth"},
+			wantKeyUsage:    []string{"DigitalSignature", "KeyEncipherment", "DecipherOnly"},
+			wantExtKeyUsage: []string{"ClientAuth", "ServerAuth"},
+		},
+		{
+			name:            "all ext key usages",
+			usage:           []string{"any", "ipsec end system", "ipsec tunnel", "ipsec user", "timestamping", "netscape sgc"},
+			wantExtKeyUsage: []string{"Any", "IPSECEndSystem", "IPSECTunnel", "IPSECUser", "TimeStamping", "NetscapeServerGatedCrypto"},
+		},
+		{
+			name:         "all key usages",
+			usage:        []string{"digital signature", "content commitment", "key encipherment", "data encipherment", "key agreement", "cert sign", "crl sign", "encipher only", "decipher only"},
+			wantKeyUsage: []string{"DigitalSignature", "ContentCommitment", "KeyEncipherment", "DataEncipherment", "KeyAgreement", "CertSign", "CRLSign", "EncipherOnly", "DecipherOnly"},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseUsages(tt.usage)
+			require.NoError(t, err)
+			keyUsage, extKeyUsage := u.vaultParams()
+			assert.Equal(t, tt.wantKeyUsage, keyUsage)
+			assert.Equal(t, tt.wantExtKeyUsage, extKeyUsage)
+		})
+	}
+}
+
+func TestUsagesVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		usage   []string
+		ku      x509.KeyUsage
+		eku     []x509.ExtKeyUsage
+		wantErr bool
+	}{
+		{
+			name:  "exact",
+			usage: []string{"digital signature", "server auth"},
+			ku:    x509.KeyUsageDigitalSignature,
+			eku:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name:  "more usages than requested",
+			usage: []string{"digital signature", "server auth"},
+			ku:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			eku:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name:  "any ext key usage",
+			usage: []string{"server auth", "client auth"},
+			eku:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		},
+		{
+			name:    "lacks key usage",
+			usage:   []string{"digital signature", "key encipherment"},
+			ku:      x509.KeyUsageDigitalSignature,
+			wantErr: true,
+		},
+		{
+			name:    "lacks ext key usage",
+			usage:   []string{"server auth", "client auth"},
+			eku:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := parseUsages(tt.usage)
+			require.NoError(t, err)
+			err = u.verify(testCertificateOf(t, tt.ku, tt.eku, nil))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
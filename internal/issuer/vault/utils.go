@@ -1,43 +1,37 @@
 package vault
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"os"
 	"path"
-	"reflect"
-)
-
-func storeKeyPair(filepath string, name string, crt, key []byte) error {
-	if err := os.MkdirAll(filepath, 0777); err != nil {
-		return fmt.Errorf("mkdir all %s : %w", filepath, err)
-	}
+	"time"
 
-	if crt != nil {
-		crtPath := path.Join(filepath, name+".pem")
-		data, err := os.ReadFile(crtPath)
-		if err != nil || !reflect.DeepEqual(crt, data) {
-			if err := os.WriteFile(crtPath, crt, 0644); err != nil {
-				return fmt.Errorf("failed to save certificate: %w", err)
-			}
-		}
-	}
+	"github.com/fraima/key-keeper/internal/config"
+)
 
-	if key != nil {
-		keyPath := path.Join(filepath, name+"-key.pem")
-		data, err := os.ReadFile(keyPath)
-		if err != nil || !reflect.DeepEqual(key, data) {
-			if err := os.WriteFile(keyPath, key, 0600); err != nil {
-				return fmt.Errorf("failed to save key file: %w", err)
-			}
-		}
-	}
-	return nil
+// checkedFile is a state of the certificate file at the last check.
+type checkedFile struct {
+	modTime time.Time
+	size    int64
+	// key file is tracked to verify the pair again when only the key is changed
+	keyModTime time.Time
+	keySize    int64
+	notBefore  time.Time
+	notAfter   time.Time
+	serial     string
+	crt        *x509.Certificate
+	// caFingerprint is the issuing CA the certificate was verified with
+	caFingerprint [sha256.Size]byte
+	// ignoreDrift is set if the certificate differs from the spec right after the issue,
+	// e.g. Vault role overrides subject, so it is not reissued in a loop
+	ignoreDrift bool
 }
 
-func readCertificate(filepath string, name string) (*x509.Certificate, error) {
-	certPath := path.Join(filepath, name+".pem")
+func readCertificate(filepath string, cert config.Certificate) (*x509.Certificate, error) {
+	certPath := path.Join(filepath, certificateFile(cert))
 	crt, err := os.ReadFile(certPath)
 	if err != nil {
 		return nil, err
@@ -47,13 +41,37 @@ func readCertificate(filepath string, name string) (*x509.Certificate, error) {
 
 func parseCertificate(crt []byte) (*x509.Certificate, error) {
 	pBlock, _ := pem.Decode(crt)
+	if pBlock == nil {
+		return nil, errPEMIsCorrupt
+	}
+	if pBlock.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("unexpected PEM block %s", pBlock.Type)
+	}
 	return x509.ParseCertificate(pBlock.Bytes)
 }
 
-func writeToFile(filepath string, date []byte) error {
-	dir := path.Dir(filepath)
-	if err := os.MkdirAll(dir, 0777); err != nil {
+// moveFile renames file and falls back to copy when the archive is on another device.
+// A link is replaced with the content of its target.
+func moveFile(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if err = os.Rename(src, dst); err == nil || os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if info, err = os.Stat(src); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(dst, data, info.Mode().Perm()); err != nil {
 		return err
 	}
-	return os.WriteFile(filepath, date, 0644)
+	return os.Remove(src)
 }
@@ -0,0 +1,122 @@
+package vault
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+func validateCertificate(cert config.Certificate) error {
+	if cert.Name == "" {
+		return errNameIsEmpty
+	}
+	if cert.HostPath == "" {
+		return errHostPathIsEmpty
+	}
+	if err := validatePrivateKey(cert.Spec.PrivateKey); err != nil {
+		return err
+	}
+	if _, err := parseUsages(cert.Spec.Usage); err != nil {
+		return err
+	}
+	if err := validateIPAddresses(cert.Spec.IPAddresses); err != nil {
+		return err
+	}
+	if err := validateSANs(cert.Spec); err != nil {
+		return err
+	}
+	if err := validatePermissions(cert.Permissions); err != nil {
+		return err
+	}
+	if err := validateHistory(cert.History); err != nil {
+		return err
+	}
+	if err := validateOutputs(cert.Outputs); err != nil {
+		return err
+	}
+	if err := validateFiles(cert); err != nil {
+		return err
+	}
+	return validateOnDelete(cert.OnDelete)
+}
+
+func validateSecret(secret config.Secret) error {
+	if secret.Name == "" {
+		return errNameIsEmpty
+	}
+	if secret.HostPath == "" {
+		return errHostPathIsEmpty
+	}
+	if err := validatePermissions(secret.Permissions); err != nil {
+		return err
+	}
+	return validateOnDelete(secret.OnDelete)
+}
+
+func validateIPAddresses(cfg config.IPAddresses) error {
+	for _, ip := range cfg.Static {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("ipAddresses: invalid static ip %s", ip)
+		}
+	}
+	for _, family := range []string{cfg.Family.Static, cfg.Family.Interfaces, cfg.Family.DNSLookup} {
+		switch ipFamilyOrDefault(family, ipFamilyBoth) {
+		case ipFamilyIPv4, ipFamilyIPv6, ipFamilyBoth:
+		default:
+			return fmt.Errorf("ipAddresses: unknown family %s", family)
+		}
+	}
+	return nil
+}
+
+func validateSANs(spec config.Spec) error {
+	for _, uri := range spec.URIs {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("uris: %w", err)
+		}
+		if u.Scheme == "" {
+			return fmt.Errorf("uris: uri %s has no scheme", uri)
+		}
+	}
+	for _, email := range spec.Emails {
+		if !strings.Contains(email, "@") {
+			return fmt.Errorf("emails: invalid email %s", email)
+		}
+	}
+
+	id := spec.SpiffeID
+	switch {
+	case id.TrustDomain == "" && id.Path != "":
+		return fmt.Errorf("spiffeID: trust domain is empty")
+	case strings.ContainsAny(id.TrustDomain, "/:@"):
+		return fmt.Errorf("spiffeID: invalid trust domain %s", id.TrustDomain)
+	}
+	return nil
+}
+
+func validateHistory(history config.History) error {
+	if history.Limit < 0 {
+		return fmt.Errorf("history: negative limit %d", history.Limit)
+	}
+	if history.MaxAge < 0 {
+		return fmt.Errorf("history: negative max age %s", history.MaxAge)
+	}
+	return nil
+}
+
+func validateOnDelete(onDelete config.OnDelete) error {
+	switch onDelete.Policy {
+	case "", onDeleteKeep, onDeleteDelete:
+		return nil
+	case onDeleteArchive:
+		if onDelete.ArchiveDir == "" {
+			return fmt.Errorf("onDelete: archive dir is empty")
+		}
+		return nil
+	}
+	return fmt.Errorf("onDelete: unknown policy %s", onDelete.Policy)
+}
@@ -1,6 +1,14 @@
 package vault
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
 	"github.com/fraima/key-keeper/internal/config"
 	"github.com/fraima/key-keeper/internal/controller"
 )
@@ -10,17 +18,29 @@ type Client interface {
 	Write(path string, data map[string]interface{}) (map[string]interface{}, error)
 	Put(kvMountPath, secretePath string, data map[string]interface{}) error
 	Get(kvMountPath, secretePath string) (map[string]interface{}, error)
+	TokenExpiry() time.Time
+	Close()
 }
 
 type vault struct {
 	cli Client
 
-	name        string
-	role        string
-	caPath      string
-	rootCAPath  string
-	kv          string
+	name       string
+	role       string
+	caPath     string
+	rootCAPath string
+	kv         string
+
+	mu          sync.RWMutex
 	certificate map[string]config.Certificate
+	secret      map[string]config.Secret
+	reissue     map[string]struct{}
+	checked     map[string]checkedFile
+	// caPool is the issuing CA used to verify certificates on disk,
+	// caFingerprint identifies it to detect the CA rotation
+	caPool        *x509.CertPool
+	caFingerprint [sha256.Size]byte
+	caFetchedAt   time.Time
 }
 
 func Connector(
@@ -40,6 +60,9 @@ func Connector(
 			rootCAPath:  cfg.Vault.Resource.RootCAPath,
 			kv:          cfg.Vault.Resource.KV.Path,
 			certificate: make(map[string]config.Certificate),
+			secret:      make(map[string]config.Secret),
+			reissue:     make(map[string]struct{}),
+			checked:     make(map[string]checkedFile),
 		}
 		return v, nil
 	}
@@ -49,26 +72,161 @@ func (s *vault) Name() string {
 	return s.name
 }
 
-func (s *vault) AddResource(r config.Resources) {
+// TokenExpiry returns expiry time of the Vault token.
+func (s *vault) TokenExpiry() time.Time {
+	return s.cli.TokenExpiry()
+}
+
+// Close stops the token renewal of the Vault client.
+func (s *vault) Close() {
+	s.cli.Close()
+}
+
+// AddResource adds new resources or updates existing ones.
+// A certificate with the changed spec is reissued on the next ensure.
+func (s *vault) AddResource(r config.Resources) []controller.Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]controller.Result, 0, len(r.Certificates)+len(r.Secrets))
 	for _, cert := range r.Certificates {
+		result := controller.Result{
+			Resource: controller.Resource{Kind: controller.KindCertificate, Name: cert.Name},
+			Action:   controller.ActionNone,
+		}
+		if err := validateCertificate(cert); err != nil {
+			result.Action, result.Err = controller.ActionFailed, err
+			results = append(results, result)
+			continue
+		}
+
+		if old, isExist := s.certificate[cert.Name]; isExist && !reflect.DeepEqual(old.Spec, cert.Spec) {
+			s.reissue[cert.Name] = struct{}{}
+		}
 		s.certificate[cert.Name] = cert
+		results = append(results, result)
+	}
+	for _, secret := range r.Secrets {
+		result := controller.Result{
+			Resource: controller.Resource{Kind: controller.KindSecret, Name: secret.Name},
+			Action:   controller.ActionNone,
+		}
+		if err := validateSecret(secret); err != nil {
+			result.Action, result.Err = controller.ActionFailed, err
+			results = append(results, result)
+			continue
+		}
+
+		s.secret[secret.Name] = secret
+		results = append(results, result)
+	}
+	return results
+}
+
+// RemoveResource stops ensuring of resources and retires them according to their onDelete policy.
+func (s *vault) RemoveResource(r config.Resources) []controller.Result {
+	s.mu.Lock()
+	for _, cert := range r.Certificates {
+		delete(s.certificate, cert.Name)
+		delete(s.reissue, cert.Name)
+		delete(s.checked, cert.Name)
+	}
+	for _, secret := range r.Secrets {
+		delete(s.secret, secret.Name)
+	}
+	s.mu.Unlock()
+
+	results := make([]controller.Result, 0, len(r.Certificates)+len(r.Secrets))
+	for _, cert := range r.Certificates {
+		result := controller.Result{
+			Resource: controller.Resource{Kind: controller.KindCertificate, Name: cert.Name},
+			Action:   controller.ActionRetired,
+		}
+		if result.Err = s.retireCertificate(cert); result.Err != nil {
+			result.Action = controller.ActionFailed
+		}
+		results = append(results, result)
 	}
 	for _, secret := range r.Secrets {
-		go func(secret config.Secret) {
-			s.ensureSecret(secret)
-		}(secret)
+		result := controller.Result{
+			Resource: controller.Resource{Kind: controller.KindSecret, Name: secret.Name},
+			Action:   controller.ActionRetired,
+		}
+		if result.Err = s.retireSecret(secret); result.Err != nil {
+			result.Action = controller.ActionFailed
+		}
+		results = append(results, result)
 	}
-	s.EnsureResource()
+	return results
 }
 
-func (s *vault) EnsureResource() {
-	for _, cert := range s.certificate {
-		go func(c config.Certificate) {
-			if c.IsCA {
-				s.ensureCA(c)
-				return
-			}
-			s.ensureCertificate(c)
-		}(cert)
+// EnsureResource checks resource and reissues it if needed.
+// For a certificate the result contains NotAfter of the certificate on disk.
+func (s *vault) EnsureResource(r controller.Resource, mode controller.Mode) (result controller.Result) {
+	result.Resource = r
+	defer func() {
+		if result.Err != nil {
+			result.Action = controller.ActionFailed
+		}
+		if r.Kind == controller.KindCertificate {
+			s.mu.RLock()
+			result.Serial, result.NotBefore = s.checked[r.Name].serial, s.checked[r.Name].notBefore
+			s.mu.RUnlock()
+		}
+	}()
+
+	switch r.Kind {
+	case controller.KindCertificate:
+		s.mu.Lock()
+		cert, isExist := s.certificate[r.Name]
+		_, reissue := s.reissue[r.Name]
+		delete(s.reissue, r.Name)
+		s.mu.Unlock()
+
+		if !isExist {
+			result.Err = errResourceIsNotExist
+			return
+		}
+		result.Path = path.Join(cert.HostPath, certificateFile(cert))
+		perm, err := resolvePermissions(cert.Permissions)
+		if err != nil {
+			result.Err = err
+			return
+		}
+		if cert.IsCA {
+			result.Action, result.NotAfter, result.Err = s.ensureCA(cert, perm, mode)
+		} else {
+			result.Action, result.NotAfter, result.Err = s.ensureCertificate(cert, perm, mode, reissue)
+		}
+		if result.Err == nil {
+			result.Err = enforceCertificate(cert, perm)
+		}
+		if result.Err != nil && reissue {
+			s.mu.Lock()
+			s.reissue[r.Name] = struct{}{}
+			s.mu.Unlock()
+		}
+	case controller.KindSecret:
+		s.mu.RLock()
+		secret, isExist := s.secret[r.Name]
+		s.mu.RUnlock()
+
+		if !isExist {
+			result.Err = errResourceIsNotExist
+			return
+		}
+		result.Path = secret.HostPath
+		perm, err := resolvePermissions(secret.Permissions)
+		if err != nil {
+			result.Err = err
+			return
+		}
+		result.Action, result.Err = s.ensureSecret(secret, perm)
+		if result.Err == nil {
+			result.Err = enforceSecret(secret, perm)
+		}
+	default:
+		result.Err = fmt.Errorf("unknown resource type %s", r.Kind)
 	}
+	return
 }
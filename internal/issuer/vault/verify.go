@@ -0,0 +1,170 @@
+package vault
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fraima/key-keeper/internal/config"
+)
+
+// caRefreshInterval is how often the issuing CA is fetched from Vault to detect its rotation.
+const caRefreshInterval = 5 * time.Minute
+
+// verifyError reports files on disk which are unusable and must be reissued.
+type verifyError struct {
+	reason string
+	err    error
+}
+
+func (e *verifyError) Error() string {
+	if e.err == nil {
+		return e.reason
+	}
+	return fmt.Sprintf("%s: %s", e.reason, e.err)
+}
+
+func (e *verifyError) Unwrap() error {
+	return e.err
+}
+
+func isVerifyError(err error) bool {
+	var v *verifyError
+	return errors.As(err, &v)
+}
+
+// verifyKeyPair checks that the key on disk matches the certificate and the certificate chains to the issuing CA.
+func (s *vault) verifyKeyPair(hostPath string, cert config.Certificate, crt *x509.Certificate) error {
+	data, err := os.ReadFile(path.Join(hostPath, keyFile(cert)))
+	if err != nil {
+		return &verifyError{reason: "read key", err: err}
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return &verifyError{reason: "corrupt key", err: err}
+	}
+	if !publicKeyEqual(key.Public(), crt.PublicKey) {
+		return &verifyError{reason: "key does not match certificate"}
+	}
+	return s.verifyChain(crt)
+}
+
+// verifyChain checks that the certificate is signed by the issuing CA of Vault.
+// The cached CA is fetched again once if the verification fails, the CA might be rotated.
+// If the CA is unavailable the chain is not checked to not reissue certificates while Vault is down.
+func (s *vault) verifyChain(crt *x509.Certificate) error {
+	var err error
+	for _, refresh := range []bool{false, true} {
+		var roots *x509.CertPool
+		if roots, _, err = s.issuingCA(refresh); err != nil {
+			zap.L().Warn("issuing_ca", zap.String("issuer_name", s.name), zap.Error(err))
+			return nil
+		}
+		// the chain is checked at NotBefore, expiry is handled by renewBefore
+		// and a clock behind Vault does not make a fresh certificate unusable
+		_, err = crt.Verify(x509.VerifyOptions{
+			Roots:       roots,
+			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			CurrentTime: crt.NotBefore,
+		})
+		if err == nil {
+			return nil
+		}
+	}
+	return &verifyError{reason: "certificate is not issued by issuer CA", err: err}
+}
+
+// issuingCA returns the issuing CA of Vault and its fingerprint.
+// The CA is fetched again if refresh is set or the previous attempt is older than caRefreshInterval,
+// the cached CA is kept if Vault is unavailable on the periodic refresh.
+func (s *vault) issuingCA(refresh bool) (*x509.CertPool, [sha256.Size]byte, error) {
+	s.mu.RLock()
+	pool, fingerprint, fetchedAt := s.caPool, s.caFingerprint, s.caFetchedAt
+	s.mu.RUnlock()
+	if !refresh && time.Since(fetchedAt) < caRefreshInterval {
+		if pool == nil {
+			return nil, fingerprint, errCAIsUnavailable
+		}
+		return pool, fingerprint, nil
+	}
+
+	vaultPath := path.Join(s.caPath, "cert/ca")
+	ca, err := s.cli.Read(vaultPath)
+	if err != nil {
+		err = fmt.Errorf("read %s: %w", vaultPath, err)
+	} else if data, ok := ca["certificate"].(string); !ok || data == "" {
+		err = fmt.Errorf("certificate block not found in %s", vaultPath)
+	} else {
+		fetched := x509.NewCertPool()
+		if !fetched.AppendCertsFromPEM([]byte(data)) {
+			err = fmt.Errorf("parse ca from %s", vaultPath)
+		} else {
+			pool, fingerprint = fetched, sha256.Sum256([]byte(strings.TrimSpace(data)))
+		}
+	}
+	if err != nil {
+		// the next attempt is after caRefreshInterval to not wait for Vault on every check while it is down
+		s.mu.Lock()
+		s.caFetchedAt = time.Now()
+		s.mu.Unlock()
+		if pool != nil && !refresh {
+			zap.L().Warn("issuing_ca", zap.String("issuer_name", s.name), zap.Error(err))
+			return pool, fingerprint, nil
+		}
+		return nil, fingerprint, err
+	}
+
+	s.mu.Lock()
+	s.caPool, s.caFingerprint, s.caFetchedAt = pool, fingerprint, time.Now()
+	s.mu.Unlock()
+	return pool, fingerprint, nil
+}
+
+// parsePrivateKey parses PEM of the private key in PKCS1, PKCS8 or SEC1 encoding.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errPEMIsCorrupt
+	}
+
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	if k, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return k.Equal(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,164 @@
+package vault
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fraima/key-keeper/internal/config"
+	"github.com/fraima/key-keeper/internal/mocks"
+)
+
+const testCAPath = "pki"
+
+// testPermissions keep the default owner and modes.
+var testPermissions = permissions{uid: -1, gid: -1}
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	crt    *x509.Certificate
+	key    crypto.Signer
+	pem    []byte
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	ca := &testCA{serial: 0x100}
+	ca.rotate(t)
+	return ca
+}
+
+// rotate replaces the CA with a new one of a new key.
+func (ca *testCA) rotate(t *testing.T) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca.key = key
+	ca.renew(t)
+}
+
+// renew replaces the CA certificate keeping the key, certificates issued before are still valid.
+func (ca *testCA) renew(t *testing.T) {
+	t.Helper()
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(ca.serial),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, ca.key.Public(), ca.key)
+	require.NoError(t, err)
+	ca.crt, err = x509.ParseCertificate(raw)
+	require.NoError(t, err)
+	ca.pem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+}
+
+// issue returns PEM of a new certificate and its key valid from notBefore to notAfter.
+func (ca *testCA) issue(t *testing.T, notBefore, notAfter time.Time) (crt, key []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, ca.crt, &priv.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTestVault returns the issuer whose Vault serves the current certificate of the CA.
+func newTestVault(t *testing.T, ca *testCA) (*vault, *mocks.Client) {
+	t.Helper()
+	cli := &mocks.Client{}
+	cli.On("Read", testCAPath+"/cert/ca").Return(func(string) map[string]interface{} {
+		return map[string]interface{}{"certificate": string(ca.pem)}
+	}, nil).Maybe()
+
+	issuer, err := Connector(func(string, config.Vault) (Client, error) {
+		return cli, nil
+	})(config.Issuer{Name: "vault", Vault: config.Vault{Resource: config.Resource{CAPath: testCAPath}}})
+	require.NoError(t, err)
+	return issuer.(*vault), cli
+}
+
+func TestVerifyChain(t *testing.T) {
+	var (
+		ca    = newTestCA(t)
+		other = newTestCA(t)
+		now   = time.Now()
+	)
+
+	tests := []struct {
+		name    string
+		ca      *testCA
+		from    time.Time
+		to      time.Time
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			ca:   ca,
+			from: now.Add(-time.Minute),
+			to:   now.Add(time.Hour),
+		},
+		{
+			name: "expired",
+			ca:   ca,
+			from: now.Add(-2 * time.Hour),
+			to:   now.Add(-time.Hour),
+		},
+		{
+			name: "clock is behind vault",
+			ca:   ca,
+			from: now.Add(time.Minute),
+			to:   now.Add(time.Hour),
+		},
+		{
+			name:    "another ca",
+			ca:      other,
+			from:    now.Add(-time.Minute),
+			to:      now.Add(time.Hour),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestVault(t, ca)
+			data, _ := tt.ca.issue(t, tt.from, tt.to)
+			crt, err := parseCertificate(data)
+			require.NoError(t, err)
+
+			err = s.verifyChain(crt)
+			if tt.wantErr {
+				assert.True(t, isVerifyError(err), "err %v", err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
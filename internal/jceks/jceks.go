@@ -0,0 +1,227 @@
+// Package jceks encodes Java JCEKS keystores.
+// The container is the JKS one with another magic, private keys are protected
+// with PBEWithMD5AndTripleDES of the SunJCE provider.
+package jceks
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	magic   uint32 = 0xcececece
+	version uint32 = 2
+
+	privateKeyTag         uint32 = 1
+	trustedCertificateTag uint32 = 2
+
+	certificateType = "X.509"
+	saltLen         = 8
+	// iterationCount is the one of the current SunJCE key protector
+	iterationCount = 200000
+)
+
+var (
+	errPasswordIsNotASCII = errors.New("password is not printable ASCII")
+
+	// oidPBEWithMD5AndTripleDES is the key protection algorithm of SunJCE
+	oidPBEWithMD5AndTripleDES = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 19, 1}
+	whitenerMessage           = []byte("Mighty Aphrodite")
+)
+
+// PrivateKeyEntry is a PKCS#8 private key with its DER certificate chain starting with the leaf.
+type PrivateKeyEntry struct {
+	Alias            string
+	PrivateKey       []byte
+	CertificateChain [][]byte
+}
+
+// TrustedCertificateEntry is a DER certificate.
+type TrustedCertificateEntry struct {
+	Alias       string
+	Certificate []byte
+}
+
+type pbeParameter struct {
+	Salt           []byte
+	IterationCount int
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters pbeParameter
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+// Encode returns the keystore signed with the password, private keys are protected with the same password.
+// Aliases are lower cased as Java does.
+func Encode(rand io.Reader, keys []PrivateKeyEntry, certs []TrustedCertificateEntry, password string, now time.Time) ([]byte, error) {
+	if err := ValidatePassword(password); err != nil {
+		return nil, err
+	}
+
+	e := encoder{h: sha1.New()}
+	e.h.Write(utf16Password(password))
+	e.h.Write(whitenerMessage)
+
+	e.writeUint32(magic)
+	e.writeUint32(version)
+	e.writeUint32(uint32(len(keys) + len(certs)))
+
+	timestamp := uint64(now.UnixMilli())
+	for _, k := range keys {
+		protected, err := protectKey(rand, k.PrivateKey, password)
+		if err != nil {
+			return nil, fmt.Errorf("protect key %s : %w", k.Alias, err)
+		}
+		e.writeUint32(privateKeyTag)
+		e.writeString(strings.ToLower(k.Alias))
+		e.writeUint64(timestamp)
+		e.writeData(protected)
+		e.writeUint32(uint32(len(k.CertificateChain)))
+		for _, c := range k.CertificateChain {
+			e.writeString(certificateType)
+			e.writeData(c)
+		}
+	}
+	for _, c := range certs {
+		e.writeUint32(trustedCertificateTag)
+		e.writeString(strings.ToLower(c.Alias))
+		e.writeUint64(timestamp)
+		e.writeString(certificateType)
+		e.writeData(c.Certificate)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	e.buf.Write(e.h.Sum(nil))
+	return e.buf.Bytes(), nil
+}
+
+// protectKey encrypts PKCS#8 key as EncryptedPrivateKeyInfo with PBEWithMD5AndTripleDES.
+func protectKey(rand io.Reader, key []byte, password string) ([]byte, error) {
+	// SunJCE does not derive distinct keys from the same salt halves as documented,
+	// so such salt is never used
+	salt := make([]byte, saltLen)
+	for bytes.Equal(salt[:saltLen/2], salt[saltLen/2:]) {
+		if _, err := io.ReadFull(rand, salt); err != nil {
+			return nil, err
+		}
+	}
+
+	derived := deriveKey(salt, []byte(password), iterationCount)
+	block, err := des.NewTripleDESCipher(derived[:24])
+	if err != nil {
+		return nil, err
+	}
+
+	padLen := des.BlockSize - len(key)%des.BlockSize
+	data := append(append([]byte(nil), key...), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+	cipher.NewCBCEncrypter(block, derived[24:]).CryptBlocks(data, data)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: algorithmIdentifier{
+			Algorithm:  oidPBEWithMD5AndTripleDES,
+			Parameters: pbeParameter{Salt: salt, IterationCount: iterationCount},
+		},
+		EncryptedData: data,
+	})
+}
+
+// deriveKey returns 24 bytes of the triple DES key and 8 bytes of IV,
+// every half of the salt is hashed with the password iterationCount times.
+// The halves of the salt must differ.
+func deriveKey(salt, password []byte, iterationCount int) []byte {
+	const half = saltLen / 2
+
+	r := make([]byte, 0, 32)
+	for i := 0; i < 2; i++ {
+		digest := salt[i*half : i*half+half]
+		for j := 0; j < iterationCount; j++ {
+			h := md5.New()
+			h.Write(digest)
+			h.Write(password)
+			digest = h.Sum(nil)
+		}
+		r = append(r, digest...)
+	}
+	return r
+}
+
+// ValidatePassword checks the password is accepted by SunJCE PBE keys.
+func ValidatePassword(password string) error {
+	for _, c := range password {
+		if c < 0x20 || c > 0x7e {
+			return errPasswordIsNotASCII
+		}
+	}
+	return nil
+}
+
+// utf16Password returns the password as Java chars in big endian.
+func utf16Password(password string) []byte {
+	r := make([]byte, 0, len(password)*2)
+	for _, c := range password {
+		r = append(r, byte(c>>8), byte(c))
+	}
+	return r
+}
+
+// encoder writes the keystore and updates its digest, the first error stops the writing.
+type encoder struct {
+	buf bytes.Buffer
+	h   hash.Hash
+	err error
+}
+
+func (e *encoder) write(b []byte) {
+	if e.err != nil {
+		return
+	}
+	e.buf.Write(b)
+	e.h.Write(b)
+}
+
+func (e *encoder) writeUint32(v uint32) {
+	e.write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (e *encoder) writeUint64(v uint64) {
+	e.write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+// writeString writes Java modified UTF-8 which is plain UTF-8 for strings without zero and supplementary chars.
+func (e *encoder) writeString(s string) {
+	if len(s) > math.MaxUint16 || strings.IndexFunc(s, func(c rune) bool { return c == 0 || c > 0xffff }) >= 0 {
+		e.err = fmt.Errorf("invalid string %q", s)
+		return
+	}
+	e.write(binary.BigEndian.AppendUint16(nil, uint16(len(s))))
+	e.write([]byte(s))
+}
+
+func (e *encoder) writeData(b []byte) {
+	if uint64(len(b)) > math.MaxUint32 {
+		e.err = fmt.Errorf("data of %d bytes is too long", len(b))
+		return
+	}
+	e.writeUint32(uint32(len(b)))
+	e.write(b)
+}
@@ -0,0 +1,213 @@
+package jceks
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPassword = "s3cret"
+
+func TestEncode(t *testing.T) {
+	key, crt := testKeyPair(t)
+	keys := []PrivateKeyEntry{{Alias: "Server", PrivateKey: key, CertificateChain: [][]byte{crt, crt}}}
+	certs := []TrustedCertificateEntry{{Alias: "CA", Certificate: crt}}
+	now := time.UnixMilli(1700000000000)
+
+	data, err := Encode(rand.Reader, keys, certs, testPassword, now)
+	require.NoError(t, err)
+
+	entries := decode(t, data, testPassword)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, privateKeyTag, entries[0].tag)
+	assert.Equal(t, "server", entries[0].alias)
+	assert.Equal(t, now.UnixMilli(), entries[0].timestamp)
+	assert.Equal(t, key, entries[0].key)
+	assert.Equal(t, [][]byte{crt, crt}, entries[0].chain)
+
+	assert.Equal(t, trustedCertificateTag, entries[1].tag)
+	assert.Equal(t, "ca", entries[1].alias)
+	assert.Equal(t, [][]byte{crt}, entries[1].chain)
+}
+
+func TestEncodeInvalidPassword(t *testing.T) {
+	_, err := Encode(rand.Reader, nil, nil, "пароль", time.Now())
+	assert.ErrorIs(t, err, errPasswordIsNotASCII)
+}
+
+func TestProtectKeySalt(t *testing.T) {
+	var (
+		key      = []byte("key")
+		distinct = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		equal    = []byte{1, 2, 3, 4, 1, 2, 3, 4}
+	)
+
+	tests := []struct {
+		name     string
+		rand     io.Reader
+		wantSalt []byte
+		wantErr  bool
+	}{
+		{
+			name:     "distinct halves",
+			rand:     bytes.NewReader(distinct),
+			wantSalt: distinct,
+		},
+		{
+			name:     "equal halves are regenerated",
+			rand:     io.MultiReader(bytes.NewReader(equal), bytes.NewReader(distinct)),
+			wantSalt: distinct,
+		},
+		{
+			name:    "rand is exhausted",
+			rand:    bytes.NewReader(equal),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protected, err := protectKey(tt.rand, key, testPassword)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var info encryptedPrivateKeyInfo
+			_, err = asn1.Unmarshal(protected, &info)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSalt, info.Algorithm.Parameters.Salt)
+			assert.Equal(t, key, unprotectKey(t, protected, testPassword))
+		})
+	}
+}
+
+type decodedEntry struct {
+	tag       uint32
+	alias     string
+	timestamp int64
+	key       []byte
+	chain     [][]byte
+}
+
+// decode parses the keystore as Java does, checks its digest and decrypts the private keys.
+func decode(t *testing.T, data []byte, password string) []decodedEntry {
+	t.Helper()
+	require.Greater(t, len(data), sha1.Size)
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+
+	h := sha1.New()
+	h.Write(utf16Password(password))
+	h.Write(whitenerMessage)
+	h.Write(body)
+	require.Equal(t, h.Sum(nil), digest, "digest")
+
+	r := bytes.NewReader(body)
+	readUint32 := func() uint32 {
+		var v uint32
+		require.NoError(t, binary.Read(r, binary.BigEndian, &v))
+		return v
+	}
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		require.NoError(t, err)
+		return b
+	}
+	readString := func() string {
+		var n uint16
+		require.NoError(t, binary.Read(r, binary.BigEndian, &n))
+		return string(readBytes(int(n)))
+	}
+	readCertificate := func() []byte {
+		require.Equal(t, certificateType, readString())
+		return readBytes(int(readUint32()))
+	}
+
+	require.Equal(t, magic, readUint32())
+	require.Equal(t, version, readUint32())
+
+	entries := make([]decodedEntry, readUint32())
+	for i := range entries {
+		e := &entries[i]
+		e.tag = readUint32()
+		e.alias = readString()
+		var timestamp uint64
+		require.NoError(t, binary.Read(r, binary.BigEndian, &timestamp))
+		e.timestamp = int64(timestamp)
+
+		switch e.tag {
+		case privateKeyTag:
+			e.key = unprotectKey(t, readBytes(int(readUint32())), password)
+			for n := readUint32(); n > 0; n-- {
+				e.chain = append(e.chain, readCertificate())
+			}
+		case trustedCertificateTag:
+			e.chain = [][]byte{readCertificate()}
+		default:
+			require.Failf(t, "unknown tag", "%d", e.tag)
+		}
+	}
+	require.Zero(t, r.Len(), "trailing data")
+	return entries
+}
+
+// unprotectKey decrypts EncryptedPrivateKeyInfo of PBEWithMD5AndTripleDES.
+func unprotectKey(t *testing.T, protected []byte, password string) []byte {
+	t.Helper()
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(protected, &info)
+	require.NoError(t, err)
+	require.Empty(t, rest)
+	require.Equal(t, oidPBEWithMD5AndTripleDES, info.Algorithm.Algorithm)
+
+	params := info.Algorithm.Parameters
+	require.Len(t, params.Salt, saltLen)
+	require.NotEqual(t, params.Salt[:saltLen/2], params.Salt[saltLen/2:], "salt halves")
+
+	derived := deriveKey(params.Salt, []byte(password), params.IterationCount)
+	block, err := des.NewTripleDESCipher(derived[:24])
+	require.NoError(t, err)
+	require.Zero(t, len(info.EncryptedData)%des.BlockSize)
+
+	data := append([]byte(nil), info.EncryptedData...)
+	cipher.NewCBCDecrypter(block, derived[24:]).CryptBlocks(data, data)
+	padLen := int(data[len(data)-1])
+	require.True(t, padLen > 0 && padLen <= des.BlockSize, "padding %d", padLen)
+	return data[:len(data)-padLen]
+}
+
+func testKeyPair(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	crt, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	return key, crt
+}
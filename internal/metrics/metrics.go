@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "key_keeper"
+
+// Result label values.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+var (
+	// CertificateExpiry is NotAfter of the certificate on disk.
+	CertificateExpiry = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "certificate_expiry_timestamp_seconds",
+			Help:      "NotAfter of the certificate as unix timestamp.",
+		},
+		[]string{"issuer", "name"},
+	)
+
+	// IssuanceTotal counts issuance and renewal of resources.
+	IssuanceTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "issuance_total",
+			Help:      "Number of resource issuances and renewals by result.",
+		},
+		[]string{"issuer", "type", "name", "result"},
+	)
+
+	// VaultRequestDuration is a latency of requests to Vault.
+	VaultRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "vault_request_duration_seconds",
+			Help:      "Latency of requests to Vault.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"issuer", "operation", "result"},
+	)
+
+	// TokenRenewalTotal counts renewals of AppRole token.
+	TokenRenewalTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_renewal_total",
+			Help:      "Number of Vault AppRole token renewals by result.",
+		},
+		[]string{"issuer", "result"},
+	)
+
+	// TriggerTotal counts executions of trigger commands.
+	TriggerTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "trigger_total",
+			Help:      "Number of trigger command executions by result.",
+		},
+		[]string{"issuer", "name", "result"},
+	)
+
+	// ConfigReloadTotal counts config reloads.
+	ConfigReloadTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "config_reload_total",
+			Help:      "Number of config reloads with changes by result.",
+		},
+		[]string{"result"},
+	)
+
+	renewal = newRenewalCollector()
+)
+
+func init() {
+	prometheus.MustRegister(renewal)
+}
+
+// Handler returns http handler of metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Result returns result label value for error.
+func Result(err error) string {
+	if err != nil {
+		return Failure
+	}
+	return Success
+}
+
+// ObserveVaultRequest observes latency of the Vault request started at start.
+func ObserveVaultRequest(issuer, operation string, start time.Time, err error) {
+	VaultRequestDuration.WithLabelValues(issuer, operation, Result(err)).Observe(time.Since(start).Seconds())
+}
+
+// SetRenewal sets the planned renewal time of the certificate.
+func SetRenewal(issuer, name string, at time.Time) {
+	renewal.set(issuer, name, at)
+}
+
+// DeleteCertificate removes metrics of the retired certificate.
+func DeleteCertificate(issuer, name string) {
+	CertificateExpiry.DeleteLabelValues(issuer, name)
+	renewal.delete(issuer, name)
+}
+
+// DeleteIssuer removes certificate metrics of the disconnected issuer.
+func DeleteIssuer(issuer string) {
+	CertificateExpiry.DeletePartialMatch(prometheus.Labels{"issuer": issuer})
+	renewal.deleteIssuer(issuer)
+}
+
+type certificateKey struct {
+	issuer string
+	name   string
+}
+
+// renewalCollector exposes seconds until planned renewal calculated at scrape time.
+type renewalCollector struct {
+	desc *prometheus.Desc
+
+	mu sync.Mutex
+	at map[certificateKey]time.Time
+}
+
+func newRenewalCollector() *renewalCollector {
+	return &renewalCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "certificate", "renewal_seconds"),
+			"Seconds until the planned renewal of the certificate.",
+			[]string{"issuer", "name"},
+			nil,
+		),
+		at: make(map[certificateKey]time.Time),
+	}
+}
+
+func (s *renewalCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+func (s *renewalCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, at := range s.at {
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, time.Until(at).Seconds(), k.issuer, k.name)
+	}
+}
+
+func (s *renewalCollector) set(issuer, name string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.at[certificateKey{issuer: issuer, name: name}] = at
+}
+
+func (s *renewalCollector) delete(issuer, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.at, certificateKey{issuer: issuer, name: name})
+}
+
+func (s *renewalCollector) deleteIssuer(issuer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.at {
+		if k.issuer == issuer {
+			delete(s.at, k)
+		}
+	}
+}
@@ -2,13 +2,22 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	time "time"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // Client is an autogenerated mock type for the Client type
 type Client struct {
 	mock.Mock
 }
 
+// Close provides a mock function with given fields:
+func (_m *Client) Close() {
+	_m.Called()
+}
+
 // Get provides a mock function with given fields: kvMountPath, secretePath
 func (_m *Client) Get(kvMountPath string, secretePath string) (map[string]interface{}, error) {
 	ret := _m.Called(kvMountPath, secretePath)
@@ -69,6 +78,20 @@ func (_m *Client) Read(path string) (map[string]interface{}, error) {
 	return r0, r1
 }
 
+// TokenExpiry provides a mock function with given fields:
+func (_m *Client) TokenExpiry() time.Time {
+	ret := _m.Called()
+
+	var r0 time.Time
+	if rf, ok := ret.Get(0).(func() time.Time); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	return r0
+}
+
 // Write provides a mock function with given fields: path, data
 func (_m *Client) Write(path string, data map[string]interface{}) (map[string]interface{}, error) {
 	ret := _m.Called(path, data)
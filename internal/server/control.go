@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fraima/key-keeper/internal/controller"
+)
+
+// Controller is a state of the daemon exposed over the control socket.
+type Controller interface {
+	Status() controller.Status
+	Renew(issuer, name string) ([]controller.RenewResult, error)
+	Rollback(issuer, name, serial string) (controller.Result, error)
+}
+
+// IssuerStatus is a state of issuer passed over the control socket.
+type IssuerStatus struct {
+	Name        string    `json:"name"`
+	Connected   bool      `json:"connected"`
+	TokenExpiry time.Time `json:"tokenExpiry,omitempty"`
+}
+
+// ResourceStatus is a state of resource passed over the control socket.
+type ResourceStatus struct {
+	Issuer    string    `json:"issuer"`
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path,omitempty"`
+	Serial    string    `json:"serial,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	NotAfter  time.Time `json:"notAfter,omitempty"`
+	RenewAt   time.Time `json:"renewAt,omitempty"`
+	NextCheck time.Time `json:"nextCheck,omitempty"`
+	CheckedAt time.Time `json:"checkedAt,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Status is a state of the daemon passed over the control socket.
+type Status struct {
+	Issuers   []IssuerStatus   `json:"issuers"`
+	Resources []ResourceStatus `json:"resources"`
+}
+
+// RenewResult is a result of the forced renewal or rollback passed over the control socket.
+type RenewResult struct {
+	Issuer   string    `json:"issuer"`
+	Name     string    `json:"name"`
+	Action   string    `json:"action"`
+	Serial   string    `json:"serial,omitempty"`
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var errSocketIsInUse = errors.New("socket is in use by another process")
+
+const (
+	// dialTimeout is a time to find out whether the socket is served by another daemon
+	dialTimeout   = time.Second
+	statusTimeout = 10 * time.Second
+	// renewTimeout covers the Vault request and the trigger commands
+	renewTimeout = 5 * time.Minute
+)
+
+// ListenControl serves the control API on unix socket, the stale socket file is replaced,
+// the socket of another running daemon is never taken over.
+// Closing of the returned server removes the socket file.
+func ListenControl(socketPath string, c Controller) (io.Closer, error) {
+	if err := removeStaleSocket(socketPath); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, toStatus(c.Status()))
+	})
+	mux.HandleFunc("/renew", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		issuer, name := q.Get("issuer"), q.Get("name")
+		if q.Get("all") == "" && (issuer == "" || name == "") {
+			http.Error(w, "issuer and name or all are required", http.StatusBadRequest)
+			return
+		}
+
+		results, err := c.Renew(issuer, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, toRenewResults(results))
+	})
+	mux.HandleFunc("/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		issuer, name := q.Get("issuer"), q.Get("name")
+		if issuer == "" || name == "" {
+			http.Error(w, "issuer and name are required", http.StatusBadRequest)
+			return
+		}
+
+		result, err := c.Rollback(issuer, name, q.Get("serial"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, toRenewResult(issuer, result))
+	})
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			zap.L().Error("control socket", zap.String("path", socketPath), zap.Error(err))
+		}
+	}()
+	return srv, nil
+}
+
+// removeStaleSocket removes the socket file left by a stopped daemon.
+func removeStaleSocket(socketPath string) error {
+	info, err := os.Lstat(socketPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket", socketPath)
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, dialTimeout)
+	if err == nil {
+		conn.Close()
+		return errSocketIsInUse
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("check socket: %w", err)
+	}
+
+	if err = os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.L().Error("write control response", zap.Error(err))
+	}
+}
+
+func toStatus(st controller.Status) Status {
+	r := Status{
+		Issuers:   make([]IssuerStatus, 0, len(st.Issuers)),
+		Resources: make([]ResourceStatus, 0, len(st.Resources)),
+	}
+	for _, i := range st.Issuers {
+		r.Issuers = append(r.Issuers, IssuerStatus{
+			Name:        i.Name,
+			Connected:   i.Connected,
+			TokenExpiry: i.TokenExpiry,
+		})
+	}
+	for _, s := range st.Resources {
+		rs := ResourceStatus{
+			Issuer:    s.Issuer,
+			Type:      s.Result.Kind,
+			Name:      s.Result.Name,
+			Path:      s.Result.Path,
+			Serial:    s.Result.Serial,
+			Action:    string(s.Result.Action),
+			NotAfter:  s.Result.NotAfter,
+			RenewAt:   s.RenewAt,
+			NextCheck: s.NextCheck,
+			CheckedAt: s.CheckedAt,
+		}
+		if s.Result.Err != nil {
+			rs.Error = s.Result.Err.Error()
+		}
+		r.Resources = append(r.Resources, rs)
+	}
+	return r
+}
+
+func toRenewResults(results []controller.RenewResult) []RenewResult {
+	r := make([]RenewResult, 0, len(results))
+	for _, res := range results {
+		r = append(r, toRenewResult(res.Issuer, res.Result))
+	}
+	return r
+}
+
+func toRenewResult(issuer string, res controller.Result) RenewResult {
+	r := RenewResult{
+		Issuer:   issuer,
+		Name:     res.Name,
+		Action:   string(res.Action),
+		Serial:   res.Serial,
+		NotAfter: res.NotAfter,
+	}
+	if res.Err != nil {
+		r.Error = res.Err.Error()
+	}
+	return r
+}
+
+// ControlClient queries the daemon over the control socket.
+type ControlClient struct {
+	cli *http.Client
+}
+
+// NewControlClient returns client of the control socket.
+func NewControlClient(socketPath string) *ControlClient {
+	return &ControlClient{
+		cli: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Status returns the state of the daemon.
+func (s *ControlClient) Status() (Status, error) {
+	var st Status
+	err := s.do(http.MethodGet, "/status", statusTimeout, &st)
+	return st, err
+}
+
+// Renew forces renewal of the issuer certificate and returns the result,
+// empty issuer and name renew all certificates.
+func (s *ControlClient) Renew(issuer, name string) ([]RenewResult, error) {
+	q := url.Values{}
+	if issuer == "" && name == "" {
+		q.Set("all", "true")
+	} else {
+		q.Set("issuer", issuer)
+		q.Set("name", name)
+	}
+
+	var r []RenewResult
+	err := s.do(http.MethodPost, "/renew?"+q.Encode(), renewTimeout, &r)
+	return r, err
+}
+
+// Rollback restores the previous generation of the issuer certificate,
+// the generation with the serial if it is set, and returns the result.
+func (s *ControlClient) Rollback(issuer, name, serial string) (RenewResult, error) {
+	q := url.Values{}
+	q.Set("issuer", issuer)
+	q.Set("name", name)
+	if serial != "" {
+		q.Set("serial", serial)
+	}
+
+	var r RenewResult
+	err := s.do(http.MethodPost, "/rollback?"+q.Encode(), renewTimeout, &r)
+	return r, err
+}
+
+func (s *ControlClient) do(method, path string, timeout time.Duration, v any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://unix"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+)
+
+// Checker reports the state of the service.
+type Checker interface {
+	Ready() error
+	Healthy() error
+}
+
+// RegisterHealth registers liveness /healthz and readiness /readyz probes.
+func RegisterHealth(mux *http.ServeMux, c Checker) {
+	mux.HandleFunc("/healthz", probe(c.Healthy))
+	mux.HandleFunc("/readyz", probe(c.Ready))
+}
+
+func probe(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
+}